@@ -6,6 +6,7 @@ require (
 	github.com/spf13/cobra v1.6.1
 	github.com/spf13/viper v1.14.0
 	github.com/yuzutech/kroki-go v0.8.1
+	golang.org/x/term v0.0.0-20220722155259-a9ba230a4035
 )
 
 require (
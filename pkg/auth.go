@@ -0,0 +1,239 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Credentials contains the headers and credentials sent to Kroki
+type Credentials struct {
+	// Hosts contains the hosts (and ports) of the Kroki endpoints, the headers and credentials are only sent to these hosts
+	Hosts []string
+	// Headers are added to every request sent to Kroki
+	Headers http.Header
+	// BearerToken is sent using the Authorization header
+	BearerToken string
+	// Username and Password are sent using basic authentication
+	Username string
+	Password string
+	// Netrc is the path to a .netrc file, credentials are looked up using the endpoint host
+	Netrc string
+}
+
+// GetCredentials returns the credentials defined in the configuration
+func GetCredentials() (Credentials, error) {
+	credentials := Credentials{
+		Headers:  http.Header{},
+		Username: viper.GetString("auth.username"),
+		Password: viper.GetString("auth.password"),
+	}
+	for name, value := range viper.GetStringMapString("headers") {
+		credentials.Headers.Set(name, value)
+	}
+	token, err := getBearerToken()
+	if err != nil {
+		return credentials, err
+	}
+	credentials.BearerToken = token
+	if credentials.BearerToken != "" && credentials.Username != "" {
+		return credentials, fmt.Errorf("invalid auth: bearer token and basic authentication cannot be used together")
+	}
+	if credentials.Password != "" && credentials.Username == "" {
+		return credentials, fmt.Errorf("invalid auth: username is required when a password is defined")
+	}
+	credentials.Hosts, err = krokiHosts()
+	if err != nil {
+		return credentials, err
+	}
+	netrc := viper.GetString("auth.netrc")
+	switch strings.ToLower(netrc) {
+	case "", "false":
+	case "true":
+		credentials.Netrc = defaultNetrcPath()
+	default:
+		credentials.Netrc = netrc
+	}
+	return credentials, nil
+}
+
+// krokiHosts returns the hosts of the configured endpoints and of the routes
+func krokiHosts() ([]string, error) {
+	endpoints := Endpoints()
+	routes, err := GetRoutes()
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		if route.Endpoint != "" {
+			endpoints = append(endpoints, ResolveEndpoint(route.Endpoint))
+		}
+	}
+	var hosts []string
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %w", endpoint, err)
+		}
+		if !containsString(hosts, u.Host) {
+			hosts = append(hosts, u.Host)
+		}
+	}
+	return hosts, nil
+}
+
+// getBearerToken returns the bearer token, either defined in the configuration, in a file or in an environment variable
+func getBearerToken() (string, error) {
+	if token := viper.GetString("auth.bearer_token"); token != "" {
+		return token, nil
+	}
+	if tokenFile := viper.GetString("auth.bearer_token_file"); tokenFile != "" {
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("fail to read the bearer token file %s: %w", tokenFile, err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+	if tokenEnv := viper.GetString("auth.bearer_token_env"); tokenEnv != "" {
+		token, ok := os.LookupEnv(tokenEnv)
+		if !ok {
+			return "", fmt.Errorf("the bearer token environment variable %s is not defined", tokenEnv)
+		}
+		return strings.TrimSpace(token), nil
+	}
+	return "", nil
+}
+
+func defaultNetrcPath() string {
+	if netrc := os.Getenv("NETRC"); netrc != "" {
+		return netrc
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// authTransport is an http.RoundTripper that adds the configured headers and credentials to the requests sent to Kroki,
+// the requests sent to other hosts (for instance by another component using the default HTTP client) are left unchanged
+type authTransport struct {
+	next        http.RoundTripper
+	credentials Credentials
+	netrc       []netrcMachine
+}
+
+func newAuthTransport(next http.RoundTripper, credentials Credentials) (http.RoundTripper, error) {
+	t := &authTransport{next: next, credentials: credentials}
+	if credentials.Netrc != "" {
+		file, err := os.Open(credentials.Netrc)
+		if err != nil {
+			return nil, fmt.Errorf("fail to read the netrc file %s: %w", credentials.Netrc, err)
+		}
+		defer file.Close()
+		t.netrc, err = parseNetrc(file)
+		if err != nil {
+			return nil, fmt.Errorf("fail to parse the netrc file %s: %w", credentials.Netrc, err)
+		}
+	}
+	return t, nil
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !containsString(t.credentials.Hosts, req.URL.Host) {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for name, values := range t.credentials.Headers {
+		req.Header[name] = values
+	}
+	if req.Header.Get("Authorization") == "" {
+		if t.credentials.BearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+t.credentials.BearerToken)
+		} else if t.credentials.Username != "" {
+			req.SetBasicAuth(t.credentials.Username, t.credentials.Password)
+		} else if machine, ok := lookupNetrc(t.netrc, req.URL.Hostname()); ok {
+			req.SetBasicAuth(machine.login, machine.password)
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+type netrcMachine struct {
+	name     string
+	login    string
+	password string
+}
+
+// parseNetrc parses the content of a .netrc file, the default machine has an empty name
+func parseNetrc(reader io.Reader) ([]netrcMachine, error) {
+	var machines []netrcMachine
+	var current *netrcMachine
+	scanner := bufio.NewScanner(reader)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// a macro definition ends with an empty line
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			switch fields[i] {
+			case "machine", "default":
+				machines = append(machines, netrcMachine{})
+				current = &machines[len(machines)-1]
+				if fields[i] == "machine" {
+					if i+1 >= len(fields) {
+						return nil, fmt.Errorf("missing machine name")
+					}
+					i++
+					current.name = fields[i]
+				}
+			case "login", "password", "account":
+				if current == nil || i+1 >= len(fields) {
+					return nil, fmt.Errorf("unexpected token %s", fields[i])
+				}
+				i++
+				if fields[i-1] == "login" {
+					current.login = fields[i]
+				} else if fields[i-1] == "password" {
+					current.password = fields[i]
+				}
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	return machines, scanner.Err()
+}
+
+func lookupNetrc(machines []netrcMachine, host string) (netrcMachine, bool) {
+	var fallback *netrcMachine
+	for i, machine := range machines {
+		if machine.name == host {
+			return machine, true
+		}
+		if machine.name == "" && fallback == nil {
+			fallback = &machines[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return netrcMachine{}, false
+}
@@ -0,0 +1,274 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strategy defines how an endpoint is selected when multiple endpoints are configured
+type Strategy string
+
+const (
+	// Failover always uses the first available endpoint, in the configured order
+	Failover Strategy = "failover"
+	// RoundRobin distributes the requests evenly across the available endpoints
+	RoundRobin Strategy = "round-robin"
+	// LeastLatency uses the available endpoint with the lowest observed latency
+	LeastLatency Strategy = "least-latency"
+)
+
+// healthCheckPath is the Kroki endpoint used to check if an ejected endpoint is available again
+const healthCheckPath = "/health"
+
+// healthCheckTimeout is the maximum duration of a health check
+const healthCheckTimeout = 5 * time.Second
+
+// CircuitBreaker defines when an endpoint is temporarily ejected
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures after which an endpoint is ejected
+	Threshold int
+	// Cooldown is the duration during which an ejected endpoint is not used
+	Cooldown time.Duration
+}
+
+type endpointState struct {
+	url          *url.URL
+	failures     int
+	ejectedUntil time.Time
+	// latency is an exponentially weighted moving average of the response time
+	latency time.Duration
+}
+
+// balancerTransport is an http.RoundTripper that sends the requests targeting the base URL to one of the endpoints
+// when an endpoint is unreachable or unavailable (502, 503 or 504) the request is sent to the next endpoint
+type balancerTransport struct {
+	next      http.RoundTripper
+	base      *url.URL
+	endpoints []*endpointState
+	strategy  Strategy
+	breaker   CircuitBreaker
+	now       func() time.Time
+
+	mu      sync.Mutex
+	counter int
+}
+
+// ParseStrategy parses the name of a load balancing strategy, failover is the default strategy
+func ParseStrategy(value string) (Strategy, error) {
+	strategy := Strategy(strings.ToLower(value))
+	switch strategy {
+	case "":
+		return Failover, nil
+	case Failover, RoundRobin, LeastLatency:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("invalid strategy: %s, must be one of %s", value, []Strategy{Failover, RoundRobin, LeastLatency})
+}
+
+func newBalancerTransport(next http.RoundTripper, endpoints []string, strategy Strategy, breaker CircuitBreaker) (http.RoundTripper, error) {
+	if len(endpoints) < 2 {
+		return next, nil
+	}
+	t := &balancerTransport{
+		next:     next,
+		strategy: strategy,
+		breaker:  breaker,
+		now:      time.Now,
+	}
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %w", endpoint, err)
+		}
+		t.endpoints = append(t.endpoints, &endpointState{url: u})
+	}
+	// the Kroki client is configured with the first endpoint
+	t.base = t.endpoints[0].url
+	return t, nil
+}
+
+func (t *balancerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !hasBaseURL(req.URL, t.base) {
+		return t.next.RoundTrip(req)
+	}
+	candidates := t.candidates(req.Context())
+	for i, endpoint := range candidates {
+		endpointReq, err := rewriteRequest(req, t.base, endpoint.url, i > 0)
+		if err != nil {
+			return nil, err
+		}
+		start := t.now()
+		response, err := t.next.RoundTrip(endpointReq)
+		if req.Context().Err() != nil {
+			return response, err
+		}
+		if err == nil && !isUnavailable(response.StatusCode) {
+			t.recordSuccess(endpoint, t.now().Sub(start))
+			return response, nil
+		}
+		t.recordFailure(endpoint)
+		if i == len(candidates)-1 {
+			return response, err
+		}
+		if response != nil {
+			_, _ = io.Copy(io.Discard, response.Body)
+			_ = response.Body.Close()
+		}
+	}
+	return nil, fmt.Errorf("no endpoint available")
+}
+
+// candidates returns the endpoints in the order in which they should be tried
+func (t *balancerTransport) candidates(ctx context.Context) []*endpointState {
+	t.mu.Lock()
+	now := t.now()
+	var available []*endpointState
+	var halfOpen []*endpointState
+	var ejected []*endpointState
+	for _, endpoint := range t.endpoints {
+		switch {
+		case endpoint.ejectedUntil.IsZero():
+			available = append(available, endpoint)
+		case now.After(endpoint.ejectedUntil):
+			halfOpen = append(halfOpen, endpoint)
+		default:
+			ejected = append(ejected, endpoint)
+		}
+	}
+	t.mu.Unlock()
+
+	// the cooldown has expired, check if the endpoint is healthy before sending requests again
+	for _, endpoint := range halfOpen {
+		healthy := t.healthCheck(ctx, endpoint)
+		t.mu.Lock()
+		if healthy {
+			endpoint.failures = 0
+			endpoint.ejectedUntil = time.Time{}
+			available = append(available, endpoint)
+		} else {
+			endpoint.ejectedUntil = t.now().Add(t.breaker.Cooldown)
+			ejected = append(ejected, endpoint)
+		}
+		t.mu.Unlock()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// keep the configured order
+	sort.SliceStable(available, func(i, j int) bool {
+		return t.index(available[i]) < t.index(available[j])
+	})
+	switch t.strategy {
+	case RoundRobin:
+		if len(available) > 0 {
+			offset := t.counter % len(available)
+			t.counter++
+			available = append(available[offset:], available[:offset]...)
+		}
+	case LeastLatency:
+		// endpoints without measurement are tried first
+		sort.SliceStable(available, func(i, j int) bool {
+			return available[i].latency < available[j].latency
+		})
+	}
+	// when every endpoint is ejected, try them anyway, starting with the one that will recover first
+	sort.SliceStable(ejected, func(i, j int) bool {
+		return ejected[i].ejectedUntil.Before(ejected[j].ejectedUntil)
+	})
+	if len(available) == 0 {
+		return ejected
+	}
+	return available
+}
+
+func (t *balancerTransport) index(endpoint *endpointState) int {
+	for i, e := range t.endpoints {
+		if e == endpoint {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *balancerTransport) healthCheck(ctx context.Context, endpoint *endpointState) bool {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	u := *endpoint.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + healthCheckPath
+	u.RawQuery = ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	response, err := t.next.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	_ = response.Body.Close()
+	return response.StatusCode == http.StatusOK
+}
+
+func (t *balancerTransport) recordSuccess(endpoint *endpointState, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	endpoint.failures = 0
+	if endpoint.latency == 0 {
+		endpoint.latency = latency
+	} else {
+		endpoint.latency = (endpoint.latency*7 + latency*3) / 10
+	}
+}
+
+func (t *balancerTransport) recordFailure(endpoint *endpointState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	endpoint.failures++
+	if t.breaker.Threshold > 0 && endpoint.failures >= t.breaker.Threshold {
+		endpoint.ejectedUntil = t.now().Add(t.breaker.Cooldown)
+	}
+}
+
+// isUnavailable returns true when the status code indicates that the server (or the gateway in front of it) is unavailable
+func isUnavailable(statusCode int) bool {
+	return statusCode == http.StatusBadGateway ||
+		statusCode == http.StatusServiceUnavailable ||
+		statusCode == http.StatusGatewayTimeout
+}
+
+// hasBaseURL returns true if the URL is the base URL or a path below it (/kroki matches /kroki/svg but not /kroki2/svg)
+func hasBaseURL(u *url.URL, base *url.URL) bool {
+	basePath := strings.TrimSuffix(base.Path, "/")
+	return u.Scheme == base.Scheme && u.Host == base.Host && (u.Path == basePath || strings.HasPrefix(u.Path, basePath+"/"))
+}
+
+// rewriteRequest returns a copy of the request targeting the endpoint instead of the base URL
+func rewriteRequest(req *http.Request, base *url.URL, endpoint *url.URL, rewindBody bool) (*http.Request, error) {
+	result := req.Clone(req.Context())
+	target := *req.URL
+	target.Scheme = endpoint.Scheme
+	target.Host = endpoint.Host
+	target.User = endpoint.User
+	target.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, base.Path), "/")
+	target.RawPath = ""
+	result.URL = &target
+	result.Host = ""
+	if rewindBody && req.Body != nil {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("unable to send the request to another endpoint: body cannot be rewound")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("unable to send the request to another endpoint: %w", err)
+		}
+		result.Body = body
+	}
+	return result, nil
+}
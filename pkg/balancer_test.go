@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingServer struct {
+	*httptest.Server
+	requests int32
+	health   int32
+	status   int32
+}
+
+func newCountingServer(name string) *countingServer {
+	s := &countingServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/kroki/health" {
+			atomic.AddInt32(&s.health, 1)
+			w.WriteHeader(int(atomic.LoadInt32(&s.status)))
+			return
+		}
+		atomic.AddInt32(&s.requests, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&s.status)))
+		_, _ = w.Write([]byte(name + " " + r.URL.Path))
+	}))
+	return s
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	response, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return string(body)
+}
+
+func TestBalancerTransportFailover(t *testing.T) {
+	primary := newCountingServer("primary")
+	defer primary.Close()
+	secondary := newCountingServer("secondary")
+	defer secondary.Close()
+	transport, err := newBalancerTransport(http.DefaultTransport, []string{primary.URL + "/kroki", secondary.URL + "/kroki"}, Failover, CircuitBreaker{
+		Threshold: 2,
+		Cooldown:  time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	balancer := transport.(*balancerTransport)
+	now := time.Now()
+	balancer.now = func() time.Time { return now }
+	client := &http.Client{Transport: transport}
+
+	result := get(t, client, primary.URL+"/kroki/graphviz/svg/payload")
+	if result != "primary /kroki/graphviz/svg/payload" {
+		t.Errorf("BalancerTransport error\nexpected: %s\nactual:   %s", "primary /kroki/graphviz/svg/payload", result)
+	}
+
+	// the primary endpoint is unavailable, requests are sent to the secondary endpoint
+	atomic.StoreInt32(&primary.status, http.StatusServiceUnavailable)
+	for i := 0; i < 3; i++ {
+		result = get(t, client, primary.URL+"/kroki/graphviz/svg/payload")
+		if result != "secondary /kroki/graphviz/svg/payload" {
+			t.Errorf("BalancerTransport error\nexpected: %s\nactual:   %s", "secondary /kroki/graphviz/svg/payload", result)
+		}
+	}
+	// the primary endpoint has been ejected after two consecutive failures
+	if primary.requests != 3 {
+		t.Errorf("BalancerTransport error\nexpected: %d requests on the primary endpoint\nactual:   %d", 3, primary.requests)
+	}
+
+	// the cooldown has expired but the health check fails
+	now = now.Add(2 * time.Minute)
+	_ = get(t, client, primary.URL+"/kroki/graphviz/svg/payload")
+	if primary.health != 1 || primary.requests != 3 {
+		t.Errorf("BalancerTransport error\nexpected: %d health check and %d requests\nactual:   %d health check and %d requests", 1, 3, primary.health, primary.requests)
+	}
+
+	// the primary endpoint is healthy again
+	atomic.StoreInt32(&primary.status, http.StatusOK)
+	now = now.Add(2 * time.Minute)
+	result = get(t, client, primary.URL+"/kroki/graphviz/svg/payload")
+	if result != "primary /kroki/graphviz/svg/payload" {
+		t.Errorf("BalancerTransport error\nexpected: %s\nactual:   %s", "primary /kroki/graphviz/svg/payload", result)
+	}
+}
+
+func TestBalancerTransportConnectionError(t *testing.T) {
+	unreachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unreachable.Close()
+	secondary := newCountingServer("secondary")
+	defer secondary.Close()
+	transport, _ := newBalancerTransport(http.DefaultTransport, []string{unreachable.URL, secondary.URL}, Failover, CircuitBreaker{
+		Threshold: 3,
+		Cooldown:  time.Minute,
+	})
+	client := &http.Client{Transport: transport}
+	response, err := client.Post(unreachable.URL+"/plantuml/svg", "text/plain", strings.NewReader("Bob -> Alice"))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if string(body) != "secondary /plantuml/svg" {
+		t.Errorf("BalancerTransportConnectionError error\nexpected: %s\nactual:   %s", "secondary /plantuml/svg", string(body))
+	}
+}
+
+func TestBalancerTransportRoundRobin(t *testing.T) {
+	first := newCountingServer("first")
+	defer first.Close()
+	second := newCountingServer("second")
+	defer second.Close()
+	transport, _ := newBalancerTransport(http.DefaultTransport, []string{first.URL, second.URL}, RoundRobin, CircuitBreaker{
+		Threshold: 3,
+		Cooldown:  time.Minute,
+	})
+	client := &http.Client{Transport: transport}
+	expected := []string{"first /d2/svg", "second /d2/svg", "first /d2/svg", "second /d2/svg"}
+	for _, e := range expected {
+		result := get(t, client, first.URL+"/d2/svg")
+		if result != e {
+			t.Errorf("BalancerTransportRoundRobin error\nexpected: %s\nactual:   %s", e, result)
+		}
+	}
+}
+
+func TestBalancerTransportLeastLatency(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := newCountingServer("fast")
+	defer fast.Close()
+	transport, _ := newBalancerTransport(http.DefaultTransport, []string{slow.URL, fast.URL}, LeastLatency, CircuitBreaker{
+		Threshold: 3,
+		Cooldown:  time.Minute,
+	})
+	balancer := transport.(*balancerTransport)
+	balancer.endpoints[0].latency = 20 * time.Millisecond
+	balancer.endpoints[1].latency = time.Millisecond
+	client := &http.Client{Transport: transport}
+	result := get(t, client, slow.URL+"/vega/svg")
+	if result != "fast /vega/svg" {
+		t.Errorf("BalancerTransportLeastLatency error\nexpected: %s\nactual:   %s", "fast /vega/svg", result)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected Strategy
+	}{
+		{value: "", expected: Failover},
+		{value: "Round-Robin", expected: RoundRobin},
+		{value: "least-latency", expected: LeastLatency},
+		{value: "random", expected: ""},
+	}
+	for _, c := range cases {
+		result, _ := ParseStrategy(c.value)
+		if result != c.expected {
+			t.Errorf("ParseStrategy error\nexpected: %s\nactual:   %s", c.expected, result)
+		}
+	}
+}
+
+func TestHasBaseURL(t *testing.T) {
+	cases := []struct {
+		url      string
+		base     string
+		expected bool
+	}{
+		{url: "https://kroki.example.com/kroki/graphviz/svg", base: "https://kroki.example.com/kroki", expected: true},
+		{url: "https://kroki.example.com/kroki", base: "https://kroki.example.com/kroki/", expected: true},
+		{url: "https://kroki.example.com/kroki2/graphviz/svg", base: "https://kroki.example.com/kroki", expected: false},
+		{url: "https://kroki.example.com/graphviz/svg", base: "https://kroki.example.com", expected: true},
+		{url: "https://other.example.com/graphviz/svg", base: "https://kroki.example.com", expected: false},
+	}
+	for _, c := range cases {
+		u, _ := url.Parse(c.url)
+		base, _ := url.Parse(c.base)
+		result := hasBaseURL(u, base)
+		if result != c.expected {
+			t.Errorf("hasBaseURL(%s, %s) error\nexpected: %v\nactual:   %v", c.url, c.base, c.expected, result)
+		}
+	}
+}
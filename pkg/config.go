@@ -1,33 +1,231 @@
 package pkg
 
-import "github.com/spf13/viper"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
 
 func SetupConfig() {
 	// Default values
 	viper.SetDefault("endpoint", "https://demo.kroki.io")
 	viper.SetDefault("timeout", "20s")
-
-	// Config file name
-	viper.SetConfigName("kroki")
-
-	// Default config paths
-	viper.AddConfigPath("/etc")
-	viper.AddConfigPath("$HOME")
-	viper.AddConfigPath(".")
+	viper.SetDefault("default_format", string(kroki.SVG))
+	viper.SetDefault("retries", 0)
+	viper.SetDefault("retry_backoff", "500ms")
+	viper.SetDefault("retry_on", []string{"429", "500", "502", "503", "504"})
+	viper.SetDefault("strategy", string(Failover))
+	viper.SetDefault("circuit_breaker.threshold", 3)
+	viper.SetDefault("circuit_breaker.cooldown", "30s")
+	viper.SetDefault("hooks.timeout", "30s")
+	viper.SetDefault("serve_preview.listen", "127.0.0.1:8080")
+	viper.SetDefault("serve_preview.interval", "500ms")
+	viper.SetDefault("proxy_server.listen", "127.0.0.1:8000")
+	viper.SetDefault("proxy_server.max_request_size", 1048576)
+	viper.SetDefault("proxy_server.max_cache_size", 1073741824)
+	viper.SetDefault("proxy_server.burst", 20)
+	viper.SetDefault("mock_server.listen", "127.0.0.1:8001")
 
 	// Environment variables
 	viper.SetEnvPrefix("kroki")
-	err := viper.BindEnv("endpoint")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	for _, key := range environmentKeys {
+		err := viper.BindEnv(key)
+		if err != nil {
+			exit(err)
+		}
+	}
+}
+
+// environmentKeys contains the configuration keys that can be defined using an environment variable
+// the name of the environment variable is KROKI_ followed by the key in uppercase (and . replaced by _)
+var environmentKeys = []string{
+	"config", "type", "format", "out_file", "page", "depfile", "include_paths", "vars_file", "template", "strict_variables", "no_hooks", "hooks.timeout",
+	"svg.minify", "svg.prefix_ids", "svg.accessible", "svg.title", "svg.responsive", "embed_source", "variants", "picture", "preview", "preview_protocol", "default_type", "default_format",
+	"endpoint", "endpoints", "strategy", "timeout", "retries", "retry_backoff", "retry_on", "debug", "proxy", "no_proxy", "profile",
+	"circuit_breaker.threshold", "circuit_breaker.cooldown", "serve_preview.listen", "serve_preview.interval",
+	"proxy_server.listen", "proxy_server.cache_dir", "proxy_server.no_cache", "proxy_server.max_cache_size", "proxy_server.max_request_size", "proxy_server.rate_limit", "proxy_server.burst",
+	"mock_server.listen", "mock_server.delay",
+	"auth.bearer_token", "auth.bearer_token_file", "auth.username", "auth.password", "auth.netrc",
+	"tls.ca_file", "tls.cert_file", "tls.key_file", "tls.server_name", "tls.pin_sha256", "tls.insecure_skip_verify",
+}
+
+// EnvironmentVariable returns the name of the environment variable bound to the configuration key
+func EnvironmentVariable(key string) string {
+	return "KROKI_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// flagKeys maps the configuration keys to the name of the flags bound using BindFlag
+var flagKeys = map[string]string{}
+
+// Endpoints returns the list of configured Kroki endpoints
+// the endpoints key takes precedence over the endpoint key
+// endpoints using a Unix domain socket are replaced by the URL used by the Kroki client (see ResolveEndpoint)
+func Endpoints() []string {
+	var endpoints []string
+	for _, value := range viper.GetStringSlice("endpoints") {
+		for _, endpoint := range strings.Split(value, ",") {
+			endpoint = strings.TrimSpace(endpoint)
+			if endpoint != "" {
+				endpoints = append(endpoints, ResolveEndpoint(endpoint))
+			}
+		}
+	}
+	if len(endpoints) == 0 {
+		return []string{ResolveEndpoint(viper.GetString("endpoint"))}
+	}
+	return endpoints
+}
+
+// BindFlag binds a configuration key to a persistent flag of the given command
+func BindFlag(cmd *cobra.Command, key string, name string) {
+	err := viper.BindPFlag(key, cmd.PersistentFlags().Lookup(name))
 	if err != nil {
 		exit(err)
 	}
-	err = viper.BindEnv("timeout")
+	flagKeys[key] = name
+}
+
+// LoadCommandConfig loads the configuration that applies to the input file and the active profile
+// the configuration is loaded by the commands that need it, so that a malformed configuration file
+// does not prevent the other commands (version, help, config validate...) from running
+// the alternate config file (--config flag) replaces the discovered configuration files
+func LoadCommandConfig(cmd *cobra.Command, inputPath string) error {
+	var err error
+	if configFilePath := viper.GetString("config"); configFilePath != "" {
+		err = LoadConfigFiles([]string{configFilePath})
+	} else {
+		err = LoadConfig(inputPath)
+	}
 	if err != nil {
-		exit(err)
+		return err
+	}
+	return ApplyProfile()
+}
+
+// LoadConfig reads and merges the configuration files that apply to the input file (or to the working directory)
+// closer files override farther ones (see DiscoverConfigFiles)
+func LoadConfig(inputPath string) error {
+	return LoadConfigFiles(DiscoverConfigFiles(configDir(inputPath)))
+}
+
+// configDir returns the directory where the discovery of the configuration files starts
+func configDir(inputPath string) string {
+	if inputPath == "" || inputPath == "-" {
+		return "."
+	}
+	return filepath.Dir(inputPath)
+}
+
+// LoadConfigFiles replaces the configuration with the content of the files, merged in order
+func LoadConfigFiles(files []string) error {
+	// an empty YAML document clears the configuration
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(""))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		viper.SetConfigFile(file)
+		viper.SetConfigType(strings.TrimPrefix(filepath.Ext(file), "."))
+		err = viper.MergeInConfig()
+		if err != nil {
+			return fmt.Errorf("fail to read the config file %s: %w", file, err)
+		}
 	}
+	loadedConfigFiles = files
+	return nil
 }
 
+// loadedConfigFiles contains the configuration files currently loaded, from the farthest to the closest
+var loadedConfigFiles []string
 
-func InitDefaultConfig() {
-	_ = viper.ReadInConfig() // ignore error
+// DiscoverConfigFiles returns the existing configuration files, from the farthest to the closest:
+//   - /etc/kroki.yml
+//   - $XDG_CONFIG_HOME/kroki/config.yml (default: $HOME/.config/kroki/config.yml)
+//   - $HOME/kroki.yml
+//   - kroki.yml in the working directory
+//   - kroki.yml in every directory from the repository root down to the given directory
+//
+// All the extensions supported by viper are accepted (for instance: kroki.json or kroki.toml).
+func DiscoverConfigFiles(dir string) []string {
+	var files []string
+	seen := map[string]bool{}
+	add := func(file string) {
+		if file == "" || seen[file] {
+			return
+		}
+		seen[file] = true
+		files = append(files, file)
+	}
+	add(findConfigFile("/etc", "kroki"))
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	home, _ := os.UserHomeDir()
+	if configHome == "" && home != "" {
+		configHome = filepath.Join(home, ".config")
+	}
+	if configHome != "" {
+		add(findConfigFile(filepath.Join(configHome, "kroki"), "config"))
+	}
+	if home != "" {
+		add(findConfigFile(home, "kroki"))
+	}
+	projectDirs := projectDirectories(dir)
+	if workingDir, err := filepath.Abs("."); err == nil && !containsString(projectDirs, workingDir) {
+		add(findConfigFile(workingDir, "kroki"))
+	}
+	for _, projectDir := range projectDirs {
+		add(findConfigFile(projectDir, "kroki"))
+	}
+	return files
+}
+
+// projectDirectories returns the directories from the repository root down to the given directory
+// when the directory is not inside a repository, only the directory itself is returned
+func projectDirectories(dir string) []string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	dirs := []string{dir}
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			// reverse the order: from the repository root to the directory
+			for i, j := 0, len(dirs)-1; i < j; i, j = i+1, j-1 {
+				dirs[i], dirs[j] = dirs[j], dirs[i]
+			}
+			return dirs
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return []string{dir}
+		}
+		current = parent
+		dirs = append(dirs, current)
+	}
+}
+
+// findConfigFile returns the path of the configuration file named name in the directory or an empty string
+func findConfigFile(dir string, name string) string {
+	for _, ext := range viper.SupportedExts {
+		file := filepath.Join(dir, name+"."+ext)
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file
+		}
+	}
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,669 @@
+package pkg
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// keyKind is the expected type of the value of a configuration key
+type keyKind int
+
+const (
+	stringKind keyKind = iota
+	durationKind
+	intKind
+	floatKind
+	boolKind
+	// listKind accepts a list or a comma-separated string
+	listKind
+	// mapKind accepts a map with arbitrary keys and scalar values
+	mapKind
+	routesKind
+	hooksKind
+	themesKind
+	profilesKind
+)
+
+// configKeys contains the known configuration keys, nested keys use the dot notation
+var configKeys = map[string]keyKind{
+	"type":                          stringKind,
+	"format":                        stringKind,
+	"out_file":                      stringKind,
+	"page":                          intKind,
+	"depfile":                       stringKind,
+	"include_paths":                 listKind,
+	"var":                           listKind,
+	"variables":                     mapKind,
+	"vars_file":                     stringKind,
+	"template":                      stringKind,
+	"strict_variables":              boolKind,
+	"default_type":                  stringKind,
+	"default_format":                stringKind,
+	"extensions":                    mapKind,
+	"aliases":                       mapKind,
+	"endpoint":                      stringKind,
+	"endpoints":                     listKind,
+	"strategy":                      stringKind,
+	"timeout":                       durationKind,
+	"retries":                       intKind,
+	"retry_backoff":                 durationKind,
+	"retry_on":                      listKind,
+	"circuit_breaker.threshold":     intKind,
+	"circuit_breaker.cooldown":      durationKind,
+	"routes":                        routesKind,
+	"hooks.timeout":                 durationKind,
+	"hooks.pre":                     hooksKind,
+	"hooks.post":                    hooksKind,
+	"no_hooks":                      boolKind,
+	"headers":                       mapKind,
+	"auth.bearer_token":             stringKind,
+	"auth.bearer_token_file":        stringKind,
+	"auth.bearer_token_env":         stringKind,
+	"auth.username":                 stringKind,
+	"auth.password":                 stringKind,
+	"auth.netrc":                    stringKind,
+	"tls.ca_file":                   stringKind,
+	"tls.cert_file":                 stringKind,
+	"tls.key_file":                  stringKind,
+	"tls.server_name":               stringKind,
+	"tls.pin_sha256":                listKind,
+	"tls.insecure_skip_verify":      boolKind,
+	"proxy":                         stringKind,
+	"no_proxy":                      stringKind,
+	"options":                       mapKind,
+	"debug":                         boolKind,
+	"svg.minify":                    boolKind,
+	"svg.prefix_ids":                boolKind,
+	"svg.accessible":                boolKind,
+	"svg.title":                     stringKind,
+	"svg.responsive":                boolKind,
+	"embed_source":                  boolKind,
+	"variants":                      listKind,
+	"picture":                       boolKind,
+	"preview":                       boolKind,
+	"preview_protocol":              stringKind,
+	"serve_preview.listen":          stringKind,
+	"serve_preview.interval":        durationKind,
+	"proxy_server.listen":           stringKind,
+	"proxy_server.cache_dir":        stringKind,
+	"proxy_server.no_cache":         boolKind,
+	"proxy_server.max_cache_size":   intKind,
+	"proxy_server.max_request_size": intKind,
+	"proxy_server.rate_limit":       floatKind,
+	"proxy_server.burst":            intKind,
+	"mock_server.listen":            stringKind,
+	"mock_server.delay":             durationKind,
+	"themes":                        themesKind,
+	"profile":                       stringKind,
+	"default_profile":               stringKind,
+	"profiles":                      profilesKind,
+}
+
+// routeKeys contains the known keys of a route
+var routeKeys = map[string]keyKind{
+	"types":    listKind,
+	"endpoint": stringKind,
+	"timeout":  durationKind,
+}
+
+// hookKeys contains the known keys of a hook
+var hookKeys = map[string]keyKind{
+	"command": stringKind,
+	"types":   listKind,
+	"timeout": durationKind,
+}
+
+// themeKeys contains the known keys of a theme (themes.VARIANT.TYPE)
+var themeKeys = map[string]keyKind{
+	"header":  stringKind,
+	"options": mapKind,
+}
+
+// ValidateSettings checks the settings against the known configuration keys, the prefix is used for nested maps
+func ValidateSettings(settings map[string]interface{}, prefix string, schema map[string]keyKind) []error {
+	var errs []error
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := prefix + strings.ToLower(name)
+		value := settings[name]
+		if kind, ok := schema[key]; ok {
+			errs = append(errs, validateValue(key, kind, value)...)
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok && isSection(key, schema) {
+			errs = append(errs, ValidateSettings(nested, key+".", schema)...)
+			continue
+		}
+		errs = append(errs, fmt.Errorf("unknown key: %s", key))
+	}
+	return errs
+}
+
+// isSection returns true if the key contains nested keys (for instance: auth or tls)
+func isSection(key string, schema map[string]keyKind) bool {
+	for name := range schema {
+		if strings.HasPrefix(name, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func validateValue(key string, kind keyKind, value interface{}) []error {
+	invalid := func(expected string) []error {
+		return []error{fmt.Errorf("invalid value for %s: %v, expected %s", key, value, expected)}
+	}
+	switch kind {
+	case stringKind:
+		switch value.(type) {
+		case string, bool, int, int64, float64:
+			return nil
+		}
+		return invalid("a string")
+	case durationKind:
+		switch v := value.(type) {
+		case string:
+			if _, err := time.ParseDuration(v); err != nil {
+				return invalid("a duration (for instance: 20s)")
+			}
+			return nil
+		case int, int64:
+			return nil
+		}
+		return invalid("a duration (for instance: 20s)")
+	case intKind:
+		switch v := value.(type) {
+		case int, int64:
+			return nil
+		case string:
+			if _, err := strconv.Atoi(v); err == nil {
+				return nil
+			}
+		}
+		return invalid("an integer")
+	case floatKind:
+		switch v := value.(type) {
+		case int, int64, float64:
+			return nil
+		case string:
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				return nil
+			}
+		}
+		return invalid("a number")
+	case boolKind:
+		switch v := value.(type) {
+		case bool:
+			return nil
+		case string:
+			if _, err := strconv.ParseBool(v); err == nil {
+				return nil
+			}
+		}
+		return invalid("a boolean")
+	case listKind:
+		switch value.(type) {
+		case []interface{}, string:
+			return nil
+		}
+		return invalid("a list")
+	case mapKind:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("a map")
+		}
+		for name, v := range m {
+			if _, ok := v.(map[string]interface{}); ok {
+				return []error{fmt.Errorf("invalid value for %s.%s: nested maps are not supported", key, name)}
+			}
+		}
+		return nil
+	case routesKind:
+		return validateList(key, value, "route", routeKeys)
+	case hooksKind:
+		return validateList(key, value, "hook", hookKeys)
+	case themesKind:
+		variants, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("a map of variants")
+		}
+		var errs []error
+		for name, variant := range variants {
+			themes, ok := variant.(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("invalid variant %s: expected a map of diagram types", name))
+				continue
+			}
+			for diagramType, theme := range themes {
+				settings, ok := theme.(map[string]interface{})
+				if !ok {
+					errs = append(errs, fmt.Errorf("invalid theme %s.%s: expected a map", name, diagramType))
+					continue
+				}
+				errs = append(errs, prefixErrors(key+"."+name+"."+diagramType, ValidateSettings(settings, "", themeKeys))...)
+			}
+		}
+		return errs
+	case profilesKind:
+		profiles, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("a map of profiles")
+		}
+		var errs []error
+		for name, profile := range profiles {
+			settings, ok := profile.(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("invalid profile %s: expected a map", name))
+				continue
+			}
+			schema := make(map[string]keyKind, len(configKeys))
+			for k, v := range configKeys {
+				if k != "profiles" && k != "default_profile" && k != "profile" {
+					schema[k] = v
+				}
+			}
+			errs = append(errs, prefixErrors(key+"."+name, ValidateSettings(settings, "", schema))...)
+		}
+		return errs
+	}
+	return nil
+}
+
+// fileSettings returns the settings of a configuration file,
+// AllSettings splits the keys containing a dot so the values of the maps (for instance extensions) are read as is
+func fileSettings(v *viper.Viper) map[string]interface{} {
+	settings := v.AllSettings()
+	for key, kind := range configKeys {
+		if kind == mapKind && !strings.Contains(key, ".") && v.IsSet(key) {
+			settings[key] = v.Get(key)
+		}
+	}
+	return settings
+}
+
+// validateList checks a list of maps (for instance the routes) against the known keys of an item
+func validateList(key string, value interface{}, item string, schema map[string]keyKind) []error {
+	items, ok := value.([]interface{})
+	if !ok {
+		return []error{fmt.Errorf("invalid value for %s: %v, expected a list of %ss", key, value, item)}
+	}
+	var errs []error
+	for i, v := range items {
+		settings, ok := v.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid %s #%d: expected a map", item, i+1))
+			continue
+		}
+		errs = append(errs, ValidateSettings(settings, "", schema)...)
+	}
+	return prefixErrors(key, errs)
+}
+
+func prefixErrors(prefix string, errs []error) []error {
+	result := make([]error, len(errs))
+	for i, err := range errs {
+		result[i] = fmt.Errorf("%s: %w", prefix, err)
+	}
+	return result
+}
+
+// ValidateConfig checks the effective configuration, for instance that the routes and the TLS settings are valid
+func ValidateConfig() []error {
+	var errs []error
+	if _, err := ParseRetryOn(viper.GetStringSlice("retry_on")); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseStrategy(viper.GetString("strategy")); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := GetRoutes(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := GetCredentials(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, stage := range []string{"pre", "post"} {
+		if _, err := GetHooks(stage); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := GetVariants(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := PreviewProtocol(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := GetTLSConfig().Build(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := (ProxyConfig{Proxy: viper.GetString("proxy")}).ProxyFunc(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ConfigSource returns where the effective value of the key comes from: flag, env, profile, file or default
+func ConfigSource(cmd *cobra.Command, key string, fileKeys map[string]map[string]bool) string {
+	if name, ok := flagKeys[key]; ok {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			return "flag --" + name
+		}
+	}
+	if containsString(environmentKeys, key) {
+		if _, ok := os.LookupEnv(EnvironmentVariable(key)); ok {
+			return "env " + EnvironmentVariable(key)
+		}
+	}
+	if profile := ActiveProfile(); profile != "" && viper.IsSet("profiles."+profile+"."+key) {
+		return "profile " + profile
+	}
+	for i := len(loadedConfigFiles) - 1; i >= 0; i-- {
+		file := loadedConfigFiles[i]
+		if fileKeys[file][key] {
+			return file
+		}
+	}
+	return "default"
+}
+
+// readConfigFileKeys returns the keys defined in each loaded configuration file
+func readConfigFileKeys() (map[string]map[string]bool, error) {
+	result := make(map[string]map[string]bool, len(loadedConfigFiles))
+	for _, file := range loadedConfigFiles {
+		v := viper.New()
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("fail to read the config file %s: %w", file, err)
+		}
+		keys := map[string]bool{}
+		for _, key := range v.AllKeys() {
+			keys[key] = true
+			// the parent keys of a map value (for instance options) are also defined by the file
+			for i := strings.LastIndex(key, "."); i > 0; i = strings.LastIndex(key[:i], ".") {
+				keys[key[:i]] = true
+			}
+		}
+		result[file] = keys
+	}
+	return result, nil
+}
+
+// isSensitiveKey returns true if the value of the configuration key is a secret
+func isSensitiveKey(key string) bool {
+	if strings.HasPrefix(key, "headers.") {
+		return IsSensitive(strings.TrimPrefix(key, "headers."))
+	}
+	name := key[strings.LastIndex(key, ".")+1:]
+	return name == "password" || name == "bearer_token"
+}
+
+// urlKeys contains the names of the configuration keys whose value is a URL or a list of URLs (including the endpoint of the routes)
+var urlKeys = map[string]bool{"endpoint": true, "endpoints": true, "proxy": true}
+
+// redactURLs returns the value with the passwords of the URLs redacted, the value defined in the configuration is not modified
+func redactURLs(key string, value interface{}) interface{} {
+	name := key[strings.LastIndex(key, ".")+1:]
+	switch v := value.(type) {
+	case string:
+		if !urlKeys[name] {
+			return v
+		}
+		// the endpoints can be defined as a comma-separated string
+		values := strings.Split(v, ",")
+		for i, value := range values {
+			if u, err := url.Parse(strings.TrimSpace(value)); err == nil && u.User != nil {
+				values[i] = RedactURL(u)
+			}
+		}
+		return strings.Join(values, ",")
+	case []string:
+		result := make([]string, len(v))
+		for i, item := range v {
+			result[i] = fmt.Sprint(redactURLs(key, item))
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = redactURLs(key, item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = redactURLs(k, item)
+		}
+		return result
+	}
+	return value
+}
+
+func ConfigShow(cmd *cobra.Command, args []string) {
+	inputPath := ""
+	if len(args) > 0 {
+		inputPath = args[0]
+	}
+	resolved, err := cmd.Flags().GetBool("resolved")
+	if err != nil {
+		exit(err)
+	}
+	err = LoadCommandConfig(cmd, inputPath)
+	if err != nil {
+		exit(err)
+	}
+	fileKeys, err := readConfigFileKeys()
+	if err != nil {
+		exit(err)
+	}
+	keys := viper.AllKeys()
+	sort.Strings(keys)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, key := range keys {
+		if strings.HasPrefix(key, "profiles.") {
+			continue
+		}
+		value := viper.Get(key)
+		if value == nil || value == "" || fmt.Sprint(value) == "[]" {
+			continue
+		}
+		formatted := fmt.Sprint(redactURLs(key, value))
+		if isSensitiveKey(key) {
+			formatted = redacted
+		}
+		if resolved {
+			fmt.Fprintf(writer, "%s\t%s\t# %s\n", key, formatted, ConfigSource(cmd, key, fileKeys))
+		} else {
+			fmt.Fprintf(writer, "%s\t%s\n", key, formatted)
+		}
+	}
+	_ = writer.Flush()
+}
+
+func ConfigValidate(cmd *cobra.Command, args []string) {
+	inputPath := ""
+	if len(args) > 0 {
+		inputPath = args[0]
+	}
+	configFilePath := viper.GetString("config")
+	files := []string{configFilePath}
+	if configFilePath == "" {
+		files = DiscoverConfigFiles(configDir(inputPath))
+	}
+	valid := true
+	for _, file := range files {
+		v := viper.New()
+		v.SetConfigFile(file)
+		err := v.ReadInConfig()
+		if err != nil {
+			valid = false
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			continue
+		}
+		for _, err := range ValidateSettings(fileSettings(v), "", configKeys) {
+			valid = false
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+		}
+	}
+	if valid {
+		err := LoadCommandConfig(cmd, inputPath)
+		if err != nil {
+			exit(err)
+		}
+		for _, err := range ValidateConfig() {
+			valid = false
+			fmt.Fprintf(os.Stderr, "configuration: %v\n", err)
+		}
+	}
+	if !valid {
+		exit("invalid configuration")
+	}
+	for _, file := range files {
+		fmt.Printf("%s: valid\n", file)
+	}
+}
+
+func ConfigInit(cmd *cobra.Command, args []string) {
+	filePath := "kroki.yml"
+	if len(args) > 0 {
+		filePath = args[0]
+	}
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		exit(err)
+	}
+	if _, err := os.Stat(filePath); err == nil && !force {
+		exit(fmt.Sprintf("%s already exists, use --force to overwrite it", filePath))
+	}
+	err = os.WriteFile(filePath, []byte(starterConfig), 0644)
+	if err != nil {
+		exit(fmt.Errorf("fail to write file %s: %w", filePath, err))
+	}
+	fmt.Printf("%s created\n", filePath)
+}
+
+// starterConfig is the content of the file created by kroki config init
+const starterConfig = `# Kroki CLI configuration
+# Every setting can also be defined using an environment variable, for instance: KROKI_ENDPOINT
+
+# Kroki server and HTTP timeout
+endpoint: https://demo.kroki.io
+timeout: 20s
+
+# Diagram type used when it cannot be inferred from the file extension
+# default_type: plantuml
+# Output format used when it cannot be inferred from the output file extension
+# default_format: svg
+
+# Directories searched for the PlantUML !include files (after the directory of the diagram)
+# include_paths:
+#   - docs/common
+
+# Variables expanded in the diagram sources using ${NAME} (or {{ .name }} with template: go)
+# variables:
+#   env: production
+# strict_variables: false
+
+# SVG transformations
+# svg:
+#   minify: true
+#   prefix_ids: true
+#   accessible: true
+#   responsive: true
+
+# Embed the diagram source in the PNG, SVG and PDF images (recovered using kroki decode image.png)
+# embed_source: true
+
+# Caching reverse proxy started using kroki proxy
+# proxy_server:
+#   listen: ":8000" # all the interfaces, the requests are sent with the configured credentials
+#   cache_dir: /var/cache/kroki
+#   max_cache_size: 1073741824
+#   max_request_size: 1048576
+#   rate_limit: 10
+
+# Theme variants rendered using --variants light,dark (the built-in light and dark themes can be overridden)
+# themes:
+#   dark:
+#     plantuml:
+#       header: "!theme cyborg"
+#     d2:
+#       options:
+#         theme: "200"
+
+# Commands executed before (source on stdin) and after (image on stdin) the conversion
+# hooks:
+#   timeout: 30s
+#   pre:
+#     - command: python3 scripts/terraform-to-dot.py
+#       types: [graphviz]
+#   post:
+#     - command: svgo --input - --output -
+#       types: [mermaid, graphviz]
+
+# Additional file extensions and diagram type aliases
+# extensions:
+#   .mmd: mermaid
+#   .arch.puml: c4plantuml
+# aliases:
+#   seq: seqdiag
+
+# Multiple endpoints, the strategy is one of: failover, round-robin, least-latency
+# endpoints:
+#   - https://kroki-eu.example.com
+#   - https://kroki-us.example.com
+# strategy: failover
+# circuit_breaker:
+#   threshold: 3
+#   cooldown: 30s
+
+# Retry connection errors, timeouts, 429 and 5xx responses
+# retries: 3
+# retry_backoff: 500ms
+# retry_on: [429, 500, 502, 503, 504]
+
+# Send some diagram types to a different endpoint
+# routes:
+#   - types: [mermaid, bpmn, excalidraw, diagramsnet]
+#     endpoint: https://companion.kroki.example.com
+#     timeout: 1m
+
+# Diagram options
+# options:
+#   theme: dark
+
+# Authentication
+# headers:
+#   X-Team: docs
+# auth:
+#   bearer_token_file: /run/secrets/kroki-token
+#   netrc: true
+
+# TLS
+# tls:
+#   ca_file: /etc/ssl/corp-ca.pem
+#   cert_file: client.crt
+#   key_file: client.key
+
+# Proxy
+# proxy: http://proxy.example.com:3128
+# no_proxy: localhost,.internal.example.com
+
+# Profiles, selected using --profile, KROKI_PROFILE or default_profile
+# default_profile: staging
+# profiles:
+#   staging:
+#     endpoint: https://kroki.staging.example.com
+`
@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -35,6 +37,15 @@ func getDiagramTypeNames() map[string]kroki.DiagramType {
 	for _, v := range supportedDiagramTypes {
 		diagramTypeNames[string(v)] = v
 	}
+	// user-defined aliases, for instance: seq: seqdiag
+	for alias, name := range viper.GetStringMapString("aliases") {
+		name = strings.ToLower(name)
+		if d, ok := diagramTypeNames[name]; ok {
+			diagramTypeNames[strings.ToLower(alias)] = d
+		} else {
+			diagramTypeNames[strings.ToLower(alias)] = kroki.DiagramType(name)
+		}
+	}
 	return diagramTypeNames
 }
 
@@ -56,22 +67,31 @@ func getDiagramTypeExtensions() map[string]kroki.DiagramType {
 	for _, v := range supportedDiagramTypes {
 		diagramTypeExtensions["."+string(v)] = v
 	}
+	// user-defined extensions, the value can be a diagram type or an alias
+	for extension, name := range viper.GetStringMapString("extensions") {
+		extension = strings.ToLower(extension)
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		diagramTypeExtensions[extension], _ = GraphFormatFromValue(name)
+	}
 	return diagramTypeExtensions
 }
 
 func Convert(cmd *cobra.Command, args []string) {
 	filePath := args[0]
-	graphFormat, err := cmd.Flags().GetString("type")
+	err := LoadCommandConfig(cmd, filePath)
 	if err != nil {
 		exit(err)
 	}
-	imageFormat, err := cmd.Flags().GetString("format")
-	if err != nil {
-		exit(err)
-	}
-	outFile, err := cmd.Flags().GetString("out-file")
-	if err != nil {
-		exit(err)
+	// flags are bound to the configuration (see BindFlag)
+	graphFormat := viper.GetString("type")
+	imageFormat := viper.GetString("format")
+	outFile := viper.GetString("out_file")
+	if viper.GetBool("preview") {
+		// the PNG image is displayed in the terminal
+		imageFormat = string(kroki.PNG)
+		outFile = "-"
 	}
 	client := GetClient(cmd)
 	if filePath == "-" {
@@ -83,33 +103,15 @@ func Convert(cmd *cobra.Command, args []string) {
 }
 
 func ConvertFromReader(client kroki.Client, diagramTypeRaw string, imageFormatRaw string, outFile string, reader io.Reader) {
-	if diagramTypeRaw == "" {
-		exit("diagram type must be specify using --type flag")
-	}
-	diagramType, err := GraphFormatFromValue(diagramTypeRaw)
-	if err != nil {
-		exit(err)
-	}
-	imageFormat, err := ResolveImageFormat(imageFormatRaw, outFile)
-	if err != nil {
-		exit(err)
-	}
 	text, err := GetTextFromReader(reader)
 	if err != nil {
 		exit(err)
 	}
-	result, err := client.FromString(text, diagramType, imageFormat)
+	conversion, err := NewConversion(client, text, "", diagramTypeRaw, imageFormatRaw, outFile)
 	if err != nil {
 		exit(err)
 	}
-	if outFile == "" || outFile == "-" {
-		fmt.Println(result)
-	} else {
-		err = client.WriteToFile(outFile, result)
-		if err != nil {
-			exit(err)
-		}
-	}
+	convertDiagrams(conversion, outFile)
 }
 
 func GetTextFromReader(reader io.Reader) (result string, err error) {
@@ -118,28 +120,258 @@ func GetTextFromReader(reader io.Reader) (result string, err error) {
 }
 
 func ConvertFromFile(client kroki.Client, filePath string, graphFormatRaw string, imageFormatRaw string, outFile string) {
-	graphFormat, err := ResolveGraphFormat(graphFormatRaw, filePath)
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		exit(fmt.Errorf("fail to read file '%s': %w", filePath, err))
+	}
+	conversion, err := NewConversion(client, string(content), filePath, graphFormatRaw, imageFormatRaw, outFile)
 	if err != nil {
 		exit(err)
 	}
+	convertDiagrams(conversion, outFile)
+}
+
+// Conversion contains the diagrams of a file (or stdin) and the settings used to convert them,
+// it is shared by the convert and serve-preview commands so that both render the same images
+type Conversion struct {
+	Client kroki.Client
+	// FilePath is empty when the text is read from stdin
+	FilePath    string
+	DiagramType kroki.DiagramType
+	ImageFormat kroki.ImageFormat
+	Diagrams    []Diagram
+	// Variants are the variants selected using --variants, empty when the diagrams are rendered once without theme
+	Variants []Variant
+	// Prerequisites are the files that were read (the source file and the included files)
+	Prerequisites []string
+}
+
+// NewConversion parses the directives, resolves the diagram type, the image format and the client then prepares the diagrams of the text
+func NewConversion(client kroki.Client, text string, filePath string, diagramTypeRaw string, imageFormatRaw string, outFile string) (Conversion, error) {
+	// the directives defined in the file take precedence over the file extension but not over the flags
+	directives, text, err := ParseDirectives(text)
+	if err != nil {
+		if filePath != "" {
+			err = fmt.Errorf("%s: %w", filePath, err)
+		}
+		return Conversion{}, err
+	}
+	if diagramTypeRaw == "" {
+		diagramTypeRaw = directives.Type
+	}
+	if imageFormatRaw == "" && (outFile == "" || outFile == "-") {
+		imageFormatRaw = directives.Format
+	}
+	diagramType, err := ResolveGraphFormat(diagramTypeRaw, filePath, text)
+	if err != nil {
+		return Conversion{}, err
+	}
+	client, err = ResolveClient(client, diagramType)
+	if err != nil {
+		return Conversion{}, err
+	}
+	client, err = WithOptions(client, directives.MergeOptions(GetOptions()))
+	if err != nil {
+		return Conversion{}, err
+	}
 	imageFormat, err := ResolveImageFormat(imageFormatRaw, outFile)
 	if err != nil {
-		exit(err)
+		return Conversion{}, err
 	}
-	result, err := client.FromFile(filePath, graphFormat, imageFormat)
+	hookContext := HookContext{DiagramType: diagramType, ImageFormat: imageFormat, InputFile: filePath}
+	diagrams, prerequisites, err := PrepareDiagrams(text, filePath, hookContext)
 	if err != nil {
-		exit(err)
+		return Conversion{}, err
 	}
-	if outFile == "-" {
-		fmt.Println(result)
-	} else {
-		err = client.WriteToFile(ResolveOutputFilePath(outFile, filePath, imageFormat), result)
+	diagrams, err = SelectPage(diagrams, viper.GetInt("page"))
+	if err != nil {
+		return Conversion{}, err
+	}
+	variants, err := GetVariants()
+	if err != nil {
+		return Conversion{}, err
+	}
+	return Conversion{
+		Client:        client,
+		FilePath:      filePath,
+		DiagramType:   diagramType,
+		ImageFormat:   imageFormat,
+		Diagrams:      diagrams,
+		Variants:      variants,
+		Prerequisites: prerequisites,
+	}, nil
+}
+
+// RenderedVariants returns the variants to render, a single variant without theme when no variant is selected
+func (c Conversion) RenderedVariants() []Variant {
+	if len(c.Variants) == 0 {
+		return []Variant{{}}
+	}
+	return c.Variants
+}
+
+// Render converts the diagram in the variant, applies the SVG transformations, embeds the source then runs the post hooks,
+// outputFile is the file the image is written to (empty when the image is not written to a file)
+func (c Conversion) Render(diagram Diagram, variant Variant, outputFile string) ([]byte, error) {
+	client, text, err := variant.Apply(c.Client, diagram.Text, c.DiagramType)
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.FromString(text, c.DiagramType, c.ImageFormat)
+	if err != nil {
+		return nil, err
+	}
+	if c.ImageFormat == kroki.SVG {
+		result = transformDiagramSVG(result, diagram, c.FilePath)
+	}
+	if viper.GetBool("embed_source") {
+		result, err = embedDiagramSource(result, diagram.Text, c.DiagramType, c.ImageFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hookContext := HookContext{DiagramType: c.DiagramType, ImageFormat: c.ImageFormat, InputFile: c.FilePath, OutputFile: outputFile}
+	return RunHooks("post", []byte(result), hookContext)
+}
+
+// PrepareDiagrams applies the pre hooks, resolves the local references and expands the variables then splits the diagrams (PlantUML),
+// it returns the diagrams and the files that were read (the source file and the included files)
+func PrepareDiagrams(text string, filePath string, hookContext HookContext) ([]Diagram, []string, error) {
+	var prerequisites []string
+	if filePath != "" {
+		prerequisites = append(prerequisites, filePath)
+	}
+	source, err := RunHooks("pre", []byte(text), hookContext)
+	if err != nil {
+		return nil, prerequisites, err
+	}
+	// the Kroki server cannot read the local files referenced by the diagram
+	text, includes, err := Preprocess(hookContext.DiagramType, string(source), filePath, IncludePaths())
+	if err != nil {
+		return nil, prerequisites, err
+	}
+	prerequisites = append(prerequisites, includes...)
+	variables, err := GetVariables()
+	if err != nil {
+		return nil, prerequisites, err
+	}
+	text, err = ExpandVariables(text, variables, viper.GetString("template"), viper.GetBool("strict_variables"))
+	if err != nil {
+		return nil, prerequisites, err
+	}
+	if isPlantUML(hookContext.DiagramType) {
+		return SplitPlantUML(text), prerequisites, nil
+	}
+	return []Diagram{{Text: text}}, prerequisites, nil
+}
+
+// isBinaryFormat returns true if the images in the format are binary (PNG, JPEG and PDF)
+func isBinaryFormat(imageFormat kroki.ImageFormat) bool {
+	return imageFormat == kroki.PNG || imageFormat == kroki.JPEG || imageFormat == kroki.PDF
+}
+
+// convertDiagrams converts the diagrams and writes the results to the standard output or to the output files
+func convertDiagrams(conversion Conversion, outFile string) {
+	filePath := conversion.FilePath
+	imageFormat := conversion.ImageFormat
+	stdout := outFile == "-" || (outFile == "" && filePath == "")
+	if len(conversion.Variants) > 0 && stdout {
+		exit("the variants require an output file, please specify the output file using --out-file flag")
+	}
+	// the binary images cannot be separated once written back to back
+	if len(conversion.Diagrams) > 1 && stdout && !viper.GetBool("preview") && isBinaryFormat(imageFormat) {
+		exit("the file contains multiple diagrams, please specify the output file using --out-file flag or a single diagram using --page flag")
+	}
+	variants := conversion.RenderedVariants()
+	var outputs []string
+	for _, diagram := range conversion.Diagrams {
+		var variantOutputs []string
+		for _, variant := range variants {
+			output := ""
+			if !stdout {
+				output = DiagramOutputFilePath(outFile, filePath, imageFormat, diagram.Name)
+				if variant.Name != "" {
+					output = VariantOutputFilePath(output, variant.Name)
+				}
+			}
+			image, err := conversion.Render(diagram, variant, output)
+			if err != nil {
+				exit(err)
+			}
+			if output == "" && viper.GetBool("preview") {
+				err = renderPreview(image)
+				if err != nil {
+					exit(err)
+				}
+			} else if output == "" {
+				fmt.Println(string(image))
+			} else {
+				err = conversion.Client.WriteToFile(output, string(image))
+				if err != nil {
+					exit(err)
+				}
+				variantOutputs = append(variantOutputs, output)
+			}
+		}
+		outputs = append(outputs, variantOutputs...)
+		if viper.GetBool("picture") && variants[0].Name != "" {
+			output := DiagramOutputFilePath(outFile, filePath, imageFormat, diagram.Name)
+			alt := DiagramTitle(diagram.Text)
+			if alt == "" {
+				alt = strings.TrimSuffix(filepath.Base(output), filepath.Ext(output))
+			}
+			_, err := WritePictureSnippet(output, variants, variantOutputs, alt)
+			if err != nil {
+				exit(err)
+			}
+		}
+	}
+	if depfile := viper.GetString("depfile"); depfile != "" {
+		if len(outputs) == 0 {
+			exit("the depfile requires an output file, please specify the output file using --out-file flag")
+		}
+		err := WriteDepfile(depfile, outputs, conversion.Prerequisites)
 		if err != nil {
 			exit(err)
 		}
 	}
 }
 
+// transformDiagramSVG applies the SVG transformations defined in the configuration (svg.*)
+func transformDiagramSVG(svg string, diagram Diagram, filePath string) string {
+	svgOptions := GetSVGOptions()
+	if !svgOptions.Enabled() {
+		return svg
+	}
+	svgOptions.Prefix = IDPrefix(filePath, diagram.Name)
+	if svgOptions.Accessible && svgOptions.Title == "" {
+		svgOptions.Title = DiagramTitle(diagram.Text)
+	}
+	if filePath != "" {
+		svgOptions.DefaultTitle = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	}
+	return TransformSVG(svg, svgOptions)
+}
+
+// renderPreview displays the PNG image in the terminal using the protocol defined by preview_protocol
+func renderPreview(image []byte) error {
+	protocol, err := PreviewProtocol()
+	if err != nil {
+		return err
+	}
+	return RenderPreview(os.Stdout, image, protocol, terminalColumns())
+}
+
+// embedDiagramSource embeds the source (sent to the Kroki server) in the image
+func embedDiagramSource(image string, source string, diagramType kroki.DiagramType, imageFormat kroki.ImageFormat) (string, error) {
+	embedded, err := NewEmbeddedSource(source, diagramType)
+	if err != nil {
+		return image, err
+	}
+	result, err := EmbedSource([]byte(image), imageFormat, embedded)
+	return string(result), err
+}
+
 func ResolveOutputFilePath(outFile string, filePath string, imageFormat kroki.ImageFormat) string {
 	if outFile != "" {
 		return outFile
@@ -148,9 +380,14 @@ func ResolveOutputFilePath(outFile string, filePath string, imageFormat kroki.Im
 	return filePath[0:len(filePath)-len(fileExtension)] + "." + string(imageFormat)
 }
 
+// ResolveImageFormat returns the image format defined by the --format flag, inferred from the output file extension
+// or otherwise the default format (default_format, svg by default)
 func ResolveImageFormat(imageFormatRaw string, outFile string) (kroki.ImageFormat, error) {
 	if imageFormatRaw == "" {
 		if outFile == "" || outFile == "-" {
+			if defaultFormat := viper.GetString("default_format"); defaultFormat != "" {
+				return ImageFormatFromValue(defaultFormat)
+			}
 			return kroki.SVG, nil
 		}
 		return ImageFormatFromFile(outFile)
@@ -175,12 +412,39 @@ func ImageFormatFromFile(filePath string) (kroki.ImageFormat, error) {
 	return "", fmt.Errorf("invalid image format: %s", value)
 }
 
-func ResolveGraphFormat(graphFormatRaw string, filePath string) (kroki.DiagramType, error) {
-	if graphFormatRaw == "" {
-		return GraphFormatFromFile(filePath)
-	} else {
+// ResolveGraphFormat returns the diagram type defined by the --type flag, inferred from the file extension,
+// inferred from the content or otherwise the default type (default_type)
+// the file path is empty when the diagram is read from the standard input
+func ResolveGraphFormat(graphFormatRaw string, filePath string, text string) (kroki.DiagramType, error) {
+	if graphFormatRaw != "" {
 		return GraphFormatFromValue(graphFormatRaw)
 	}
+	if filePath != "" {
+		if diagramType, err := GraphFormatFromFile(filePath); err == nil {
+			return diagramType, nil
+		}
+	}
+	// falls back to the default type when the content is not recognized
+	return InferGraphFormat(text)
+}
+
+// InferGraphFormat returns the diagram type inferred from the content or otherwise the default type (default_type),
+// a low confidence match is only used when the default type is not defined
+func InferGraphFormat(text string) (kroki.DiagramType, error) {
+	diagramType, confidence := SniffDiagramType(text)
+	defaultType := viper.GetString("default_type")
+	if confidence > LowConfidence || (confidence == LowConfidence && defaultType == "") {
+		if confidence == LowConfidence {
+			fmt.Fprintf(os.Stderr, "warning: diagram type inferred from the content: %s (confidence: %s), please specify the diagram type using --type flag\n", diagramType, confidence)
+		} else if viper.GetBool("debug") {
+			fmt.Fprintf(os.Stderr, "diagram type inferred from the content: %s (confidence: %s)\n", diagramType, confidence)
+		}
+		return diagramType, nil
+	}
+	if defaultType != "" {
+		return GraphFormatFromValue(defaultType)
+	}
+	return "", fmt.Errorf("unable to infer the diagram type from the content, please specify the diagram type using --type flag")
 }
 
 func GraphFormatFromValue(value string) (kroki.DiagramType, error) {
@@ -192,34 +456,41 @@ func GraphFormatFromValue(value string) (kroki.DiagramType, error) {
 	return kroki.DiagramType(value), nil
 }
 
+// GraphFormatFromFile returns the diagram type inferred from the file extension,
+// extensions can contain multiple dots (for instance: .arch.puml) and the longest matching extension wins
 func GraphFormatFromFile(filePath string) (kroki.DiagramType, error) {
-	fileExtension := filepath.Ext(filePath)
-	value := strings.ToLower(fileExtension)
-	if d, ok := getDiagramTypeExtensions()[fileExtension]; ok {
-		return d, nil
+	fileName := strings.ToLower(filepath.Base(filePath))
+	diagramTypeExtensions := getDiagramTypeExtensions()
+	extensions := make([]string, 0, len(diagramTypeExtensions))
+	for extension := range diagramTypeExtensions {
+		extensions = append(extensions, extension)
+	}
+	sort.Slice(extensions, func(i, j int) bool {
+		if len(extensions[i]) != len(extensions[j]) {
+			return len(extensions[i]) > len(extensions[j])
+		}
+		return extensions[i] < extensions[j]
+	})
+	for _, extension := range extensions {
+		if len(fileName) > len(extension) && strings.HasSuffix(fileName, extension) {
+			return diagramTypeExtensions[extension], nil
+		}
 	}
+	value := strings.ToLower(filepath.Ext(filePath))
 	return "", fmt.Errorf(
 		"unable to infer the graph format from the file extension %s, please specify the diagram type using --type flag",
 		value)
 }
 
 func GetClient(cmd *cobra.Command) kroki.Client {
-	configFilePath, err := cmd.Flags().GetString("config")
+	// kroki-go sends every request using the default HTTP client
+	transport, err := NewTransport()
 	if err != nil {
 		exit(err)
 	}
-	if configFilePath != "" {
-		file, err := os.Open(configFilePath)
-		if err != nil {
-			exit(err)
-		}
-		err = viper.ReadConfig(file)
-		if err != nil {
-			exit(err)
-		}
-	}
+	http.DefaultClient.Transport = transport
 	return kroki.New(kroki.Configuration{
-		URL:     viper.GetString("endpoint"),
-		Timeout: viper.GetDuration("timeout"),
+		URL:     Endpoints()[0],
+		Timeout: RequestTimeout(viper.GetDuration("timeout")),
 	})
 }
@@ -2,19 +2,17 @@ package pkg
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"net/http/httptest"
 	"os"
-	"strconv"
-	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-cli/pkg/mock"
 	"github.com/yuzutech/kroki-go"
 )
 
@@ -205,30 +203,12 @@ func TestGraphFormatFromValue(t *testing.T) {
 }
 
 func TestConvertFromReader(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		expected := "eNpKyUwvSizIUHBXqPZIzcnJ17ULzy_KSakFBAAA__9sQAjG"
-		uri := strings.Split(r.RequestURI, "/")
-		payload := uri[len(uri)-1]
-		if payload != expected {
-			t.Errorf("ConvertFromReader error\nexpected: %s\nactual:   %s", expected, payload)
-		}
-		imageFormat := uri[len(uri)-2]
-		if imageFormat != string("svg") {
-			t.Errorf("ConvertFromReader error\nexpected: %s\nactual:   %s", "svg", imageFormat)
-		}
-		diagramType := uri[len(uri)-3]
-		if diagramType != "graphviz" {
-			t.Errorf("ConvertFromReader error\nexpected: %s\nactual:   %s", "graphviz", diagramType)
-		}
-		_, _ = w.Write([]byte("<svg>Hello</svg>"))
-	}))
+	server := mock.New()
+	server.Respond(mock.Match{}, mock.Response{Body: "<svg>Hello</svg>"})
+	ts := httptest.NewServer(server)
 	defer ts.Close()
-	port, err := strconv.ParseUint(strings.Split(ts.URL, ":")[2], 10, 16)
-	if err != nil {
-		t.Errorf("error getting the port :\n%+v", err)
-	}
 	client := kroki.New(kroki.Configuration{
-		URL:     fmt.Sprintf("http://localhost:%d", port),
+		URL:     ts.URL,
 		Timeout: time.Second * 10,
 	})
 	buf := bytes.NewBuffer([]byte(""))
@@ -240,33 +220,16 @@ func TestConvertFromReader(t *testing.T) {
 	if result != expected {
 		t.Errorf("ConvertFromReader error\nexpected: %s\nactual:   %s", expected, result)
 	}
+	checkConvertRequests(t, "ConvertFromReader", server.Requests())
 }
 
 func TestConvertFromReaderOutFile(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		expected := "eNpKyUwvSizIUHBXqPZIzcnJ17ULzy_KSakFBAAA__9sQAjG"
-		uri := strings.Split(r.RequestURI, "/")
-		payload := uri[len(uri)-1]
-		if payload != expected {
-			t.Errorf("ConvertFromReaderOutFile error\nexpected: %s\nactual:   %s", expected, payload)
-		}
-		imageFormat := uri[len(uri)-2]
-		if imageFormat != string("svg") {
-			t.Errorf("ConvertFromReaderOutFile error\nexpected: %s\nactual:   %s", "svg", imageFormat)
-		}
-		diagramType := uri[len(uri)-3]
-		if diagramType != "graphviz" {
-			t.Errorf("ConvertFromReaderOutFile error\nexpected: %s\nactual:   %s", "graphviz", diagramType)
-		}
-		_, _ = w.Write([]byte("<svg>Hello</svg>"))
-	}))
+	server := mock.New()
+	server.Respond(mock.Match{}, mock.Response{Body: "<svg>Hello</svg>"})
+	ts := httptest.NewServer(server)
 	defer ts.Close()
-	port, err := strconv.ParseUint(strings.Split(ts.URL, ":")[2], 10, 16)
-	if err != nil {
-		t.Errorf("error getting the port :\n%+v", err)
-	}
 	client := kroki.New(kroki.Configuration{
-		URL:     fmt.Sprintf("http://localhost:%d", port),
+		URL:     ts.URL,
 		Timeout: time.Second * 10,
 	})
 	buf := bytes.NewBuffer([]byte(""))
@@ -279,6 +242,27 @@ func TestConvertFromReaderOutFile(t *testing.T) {
 	if string(result) != expected {
 		t.Errorf("ConvertFromReaderOutFile error\nexpected: %s\nactual:   %s", expected, string(result))
 	}
+	checkConvertRequests(t, "ConvertFromReaderOutFile", server.Requests())
+}
+
+// checkConvertRequests checks that a single GraphViz to SVG conversion of the hello world diagram was requested
+func checkConvertRequests(t *testing.T, name string, requests []mock.Request) {
+	if len(requests) != 1 {
+		t.Fatalf("%s error\nexpected: %d request\nactual:   %d", name, 1, len(requests))
+	}
+	cases := []struct {
+		actual   string
+		expected string
+	}{
+		{actual: requests[0].DiagramType, expected: "graphviz"},
+		{actual: requests[0].OutputFormat, expected: "svg"},
+		{actual: requests[0].Source, expected: "digraph G {Hello->World}"},
+	}
+	for _, c := range cases {
+		if c.actual != c.expected {
+			t.Errorf("%s error\nexpected: %s\nactual:   %s", name, c.expected, c.actual)
+		}
+	}
 }
 
 func CaptureOutput(f func()) string {
@@ -310,3 +294,83 @@ func CaptureOutput(f func()) string {
 	_ = writer.Close()
 	return <-out
 }
+
+func TestResolveFormatsWithDefaults(t *testing.T) {
+	viper.Set("default_format", "png")
+	viper.Set("default_type", "plantuml")
+	defer viper.Set("default_format", nil)
+	defer viper.Set("default_type", nil)
+	imageFormat, _ := ResolveImageFormat("", "")
+	if imageFormat != kroki.PNG {
+		t.Errorf("ResolveImageFormat error\nexpected: %s\nactual:   %s", kroki.PNG, imageFormat)
+	}
+	// the output file extension takes precedence over the default format
+	imageFormat, _ = ResolveImageFormat("", "out.pdf")
+	if imageFormat != kroki.PDF {
+		t.Errorf("ResolveImageFormat error\nexpected: %s\nactual:   %s", kroki.PDF, imageFormat)
+	}
+	diagramType, _ := ResolveGraphFormat("", "notes.txt", "")
+	if diagramType != kroki.PlantUML {
+		t.Errorf("ResolveGraphFormat error\nexpected: %s\nactual:   %s", kroki.PlantUML, diagramType)
+	}
+	// the file extension takes precedence over the default type
+	diagramType, _ = ResolveGraphFormat("", "hello.dot", "")
+	if diagramType != kroki.GraphViz {
+		t.Errorf("ResolveGraphFormat error\nexpected: %s\nactual:   %s", kroki.GraphViz, diagramType)
+	}
+}
+
+func TestConvertFlagsEnvironmentVariables(t *testing.T) {
+	t.Setenv("KROKI_TYPE", "mermaid")
+	t.Setenv("KROKI_FORMAT", "png")
+	t.Setenv("KROKI_OUT_FILE", "out.png")
+	cases := map[string]string{
+		"type":     "mermaid",
+		"format":   "png",
+		"out_file": "out.png",
+	}
+	for key, expected := range cases {
+		if viper.GetString(key) != expected {
+			t.Errorf("viper.GetString(%s) error\nexpected: %s\nactual:   %s", key, expected, viper.GetString(key))
+		}
+	}
+}
+
+func TestGraphFormatFromFileUserDefined(t *testing.T) {
+	loadTestConfig(t, `
+extensions:
+  .mmd: mermaid
+  .arch.puml: c4plantuml
+  seq: seq
+aliases:
+  seq: seqdiag
+  flow: mermaid
+`)
+	cases := []struct {
+		filePath string
+		expected kroki.DiagramType
+	}{
+		{filePath: "hello.mmd", expected: kroki.Mermaid},
+		{filePath: "/path/to/context.arch.puml", expected: kroki.C4PlantUML},
+		{filePath: "/path/to/hello.puml", expected: kroki.PlantUML},
+		{filePath: "HELLO.ARCH.PUML", expected: kroki.C4PlantUML},
+		{filePath: "hello.seq", expected: kroki.SeqDiag},
+		{filePath: "hello.dot", expected: kroki.GraphViz},
+	}
+	for _, c := range cases {
+		result, err := GraphFormatFromFile(c.filePath)
+		if err != nil {
+			t.Errorf("GraphFormatFromFile(%s) error\n%+v", c.filePath, err)
+			continue
+		}
+		if result != c.expected {
+			t.Errorf("GraphFormatFromFile(%s) error\nexpected: %s\nactual:   %s", c.filePath, c.expected, result)
+		}
+	}
+	for alias, expected := range map[string]kroki.DiagramType{"seq": kroki.SeqDiag, "Flow": kroki.Mermaid} {
+		result, _ := GraphFormatFromValue(alias)
+		if result != expected {
+			t.Errorf("GraphFormatFromValue(%s) error\nexpected: %s\nactual:   %s", alias, expected, result)
+		}
+	}
+}
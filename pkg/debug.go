@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// redacted replaces secrets in the debug output
+const redacted = "***"
+
+// sensitiveWords identifies the headers containing secrets
+var sensitiveWords = []string{"authorization", "cookie", "token", "secret", "password", "key", "auth"}
+
+// debugTransport is an http.RoundTripper that logs the requests and responses, secrets are redacted
+type debugTransport struct {
+	next   http.RoundTripper
+	output io.Writer
+}
+
+func newDebugTransport(next http.RoundTripper, output io.Writer) http.RoundTripper {
+	return &debugTransport{next: next, output: output}
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "> %s %s\n", req.Method, RedactURL(req.URL))
+	writeHeaders(&builder, "> ", req.Header)
+	_, _ = io.WriteString(t.output, builder.String())
+
+	start := time.Now()
+	response, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	builder.Reset()
+	if err != nil {
+		fmt.Fprintf(&builder, "< error: %v (%s)\n", err, elapsed)
+	} else {
+		fmt.Fprintf(&builder, "< %s (%s)\n", response.Status, elapsed)
+		writeHeaders(&builder, "< ", response.Header)
+	}
+	_, _ = io.WriteString(t.output, builder.String())
+	return response, err
+}
+
+func writeHeaders(builder *strings.Builder, prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(builder, "%s%s: %s\n", prefix, name, RedactHeader(name, value))
+		}
+	}
+}
+
+// RedactHeader returns the header value or a redacted value if the header contains a secret
+func RedactHeader(name string, value string) string {
+	if IsSensitive(name) {
+		// keep the authentication scheme, for instance: Bearer ***
+		if scheme, _, ok := strings.Cut(value, " "); ok && strings.EqualFold(name, "Authorization") {
+			return scheme + " " + redacted
+		}
+		return redacted
+	}
+	return value
+}
+
+// IsSensitive returns true if the name (of a header or a configuration key) indicates a secret
+func IsSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactURL returns the URL with the password (if any) redacted
+func RedactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	redactedURL := *u
+	if _, ok := u.User.Password(); ok {
+		redactedURL.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	// the password is escaped by url.URL.String (%2A%2A%2A)
+	escaped := url.UserPassword("", redacted).String()
+	return strings.Replace(redactedURL.String(), escaped+"@", ":"+redacted+"@", 1)
+}
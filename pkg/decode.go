@@ -26,11 +26,16 @@ func Decode(_ *cobra.Command, args []string) {
 }
 
 func DecodeFromReader(reader io.Reader) {
-	text, err := GetTextFromReader(reader)
+	content, err := io.ReadAll(reader)
 	if err != nil {
 		exit(err)
 	}
-	result, err := DecodeInput(text)
+	var result string
+	if IsImage(content) {
+		result, err = DecodeImage(content)
+	} else {
+		result, err = DecodeInput(string(content))
+	}
 	if err != nil {
 		exit(err)
 	}
@@ -46,7 +51,31 @@ func DecodeFromInput(input string) {
 }
 
 // takes a string encoded using deflate + base64 format and returns a decoded string
+// the input can also be a URL or, when it cannot be decoded, the path of an image converted using --embed-source
 func DecodeInput(input string) (string, error) {
+	result, err := decodePayload(input)
+	if err == nil {
+		return result, nil
+	}
+	// the input is only read as a file when it is not an encoded diagram, so that its meaning does not depend on the working directory
+	if info, statErr := os.Stat(input); statErr == nil && !info.IsDir() {
+		content, readErr := os.ReadFile(input)
+		if readErr != nil {
+			return "", fmt.Errorf("fail to read file '%s': %w", input, readErr)
+		}
+		if IsImage(content) {
+			result, err := DecodeImage(content)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", input, err)
+			}
+			return result, nil
+		}
+	}
+	return "", err
+}
+
+// decodePayload decodes a diagram encoded using deflate + base64 format or the encoded diagram of a URL
+func decodePayload(input string) (string, error) {
 	// special case to extract the encoded diagram from a URL (GET request)
 	// expected format is: https://kroki.io/diagram/format/encoded
 	if strings.HasPrefix(input, "https://") || strings.HasPrefix(input, "http://") {
@@ -69,6 +98,15 @@ func DecodeInput(input string) (string, error) {
 	return out.String(), nil
 }
 
+// DecodeImage returns the diagram source embedded in a PNG, SVG or PDF image
+func DecodeImage(image []byte) (string, error) {
+	embedded, err := ExtractSource(image)
+	if err != nil {
+		return "", err
+	}
+	return decodePayload(embedded.Payload)
+}
+
 func getUrl(input string) (*url.URL, error) {
 	_, err := url.ParseRequestURI(input)
 	if err != nil {
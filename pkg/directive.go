@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Directives contains the rendering settings defined in a diagram file,
+// using a modeline (for instance: %% kroki: type=mermaid format=png theme=dark) or a YAML front matter with a kroki key
+type Directives struct {
+	Type    string
+	Format  string
+	Options map[string]string
+}
+
+// modelinePattern matches a modeline, the comment can start with %%, //, #, ', -- or ;
+var modelinePattern = regexp.MustCompile(`^\s*(%%|//|#|'|--|;)\s*kroki:(.*)$`)
+
+// ParseDirectives returns the directives defined in the content and the content without the directives
+func ParseDirectives(text string) (Directives, string, error) {
+	directives := Directives{Options: map[string]string{}}
+	text, err := parseFrontMatter(text, &directives)
+	if err != nil {
+		return directives, text, err
+	}
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		// the modeline must be the first non-empty line
+		if match := modelinePattern.FindStringSubmatch(strings.TrimRight(line, "\r\n")); match != nil {
+			err = parseModeline(match[2], &directives)
+			if err != nil {
+				return directives, text, err
+			}
+			text = strings.Join(lines[:i], "") + strings.Join(lines[i+1:], "")
+		}
+		break
+	}
+	return directives, text, nil
+}
+
+// parseModeline parses space-separated key=value pairs, values can be enclosed in double quotes
+func parseModeline(value string, directives *Directives) error {
+	for _, field := range splitFields(value) {
+		i := strings.Index(field, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid directive: %s, expected key=value", field)
+		}
+		directives.set(field[:i], strings.Trim(field[i+1:], `"`))
+	}
+	return nil
+}
+
+func splitFields(value string) []string {
+	var fields []string
+	var builder strings.Builder
+	quoted := false
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			builder.WriteRune(r)
+		case (r == ' ' || r == '\t') && !quoted:
+			if builder.Len() > 0 {
+				fields = append(fields, builder.String())
+				builder.Reset()
+			}
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	if builder.Len() > 0 {
+		fields = append(fields, builder.String())
+	}
+	return fields
+}
+
+// parseFrontMatter reads the kroki key of the YAML front matter and removes it,
+// the front matter is removed when it does not contain other keys (Mermaid uses the front matter to define the title)
+func parseFrontMatter(text string, directives *Directives) (string, error) {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return text, nil
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "---" {
+			end = i
+			break
+		}
+	}
+	if end == -1 {
+		return text, nil
+	}
+	frontMatter := lines[1:end]
+	start, stop := -1, len(frontMatter)
+	for i, line := range frontMatter {
+		if start == -1 {
+			if strings.HasPrefix(line, "kroki:") {
+				start = i
+			}
+			continue
+		}
+		// the kroki section ends at the next top-level key
+		if strings.TrimSpace(line) != "" && line[0] != ' ' && line[0] != '\t' && line[0] != '#' {
+			stop = i
+			break
+		}
+	}
+	if start == -1 {
+		return text, nil
+	}
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(strings.NewReader(strings.Join(frontMatter[start:stop], "")))
+	if err != nil {
+		return text, fmt.Errorf("invalid front matter: %w", err)
+	}
+	for key, value := range v.GetStringMap("kroki") {
+		if key == "options" {
+			for name, option := range v.GetStringMapString("kroki.options") {
+				directives.Options[name] = option
+			}
+			continue
+		}
+		if _, ok := value.(map[string]interface{}); ok {
+			return text, fmt.Errorf("invalid front matter: nested maps are not supported (kroki.%s)", key)
+		}
+		directives.set(key, fmt.Sprint(value))
+	}
+	remaining := append(append([]string{}, frontMatter[:start]...), frontMatter[stop:]...)
+	if strings.TrimSpace(strings.Join(remaining, "")) == "" {
+		return strings.Join(lines[end+1:], ""), nil
+	}
+	return lines[0] + strings.Join(remaining, "") + strings.Join(lines[end:], ""), nil
+}
+
+func (d *Directives) set(key string, value string) {
+	switch strings.ToLower(key) {
+	case "type":
+		d.Type = value
+	case "format":
+		d.Format = value
+	default:
+		d.Options[key] = value
+	}
+}
+
+// MergeOptions returns the options with the options defined by the directives, the directives take precedence
+func (d Directives) MergeOptions(options map[string]string) map[string]string {
+	result := make(map[string]string, len(options)+len(d.Options))
+	for name, value := range options {
+		result[name] = value
+	}
+	for name, value := range d.Options {
+		result[name] = value
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseDirectives(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected Directives
+		content  string
+	}{
+		{
+			text:     "%% kroki: type=mermaid format=png theme=dark\ngraph TD\n  A --> B\n",
+			expected: Directives{Type: "mermaid", Format: "png", Options: map[string]string{"theme": "dark"}},
+			content:  "graph TD\n  A --> B\n",
+		},
+		{
+			text:     "\n// kroki: format=svg title=\"Hello world\"\ndigraph G { Hello -> World }",
+			expected: Directives{Format: "svg", Options: map[string]string{"title": "Hello world"}},
+			content:  "\ndigraph G { Hello -> World }",
+		},
+		{
+			text:     "---\nkroki:\n  type: d2\n  options:\n    theme: 200\n---\nx -> y\n",
+			expected: Directives{Type: "d2", Options: map[string]string{"theme": "200"}},
+			content:  "x -> y\n",
+		},
+		{
+			// Mermaid front matter is kept
+			text:     "---\ntitle: Flow\nkroki:\n  format: png\n  scale: 2\nconfig:\n  theme: forest\n---\nflowchart LR\n",
+			expected: Directives{Format: "png", Options: map[string]string{"scale": "2"}},
+			content:  "---\ntitle: Flow\nconfig:\n  theme: forest\n---\nflowchart LR\n",
+		},
+		{
+			// the modeline must be the first non-empty line
+			text:     "@startuml\n' kroki: format=png\n@enduml",
+			expected: Directives{Options: map[string]string{}},
+			content:  "@startuml\n' kroki: format=png\n@enduml",
+		},
+	}
+	for _, c := range cases {
+		directives, content, err := ParseDirectives(c.text)
+		if err != nil {
+			t.Errorf("ParseDirectives(%q) error\n%+v", c.text, err)
+			continue
+		}
+		if fmt.Sprint(directives) != fmt.Sprint(c.expected) {
+			t.Errorf("ParseDirectives(%q) error\nexpected: %+v\nactual:   %+v", c.text, c.expected, directives)
+		}
+		if content != c.content {
+			t.Errorf("ParseDirectives(%q) error\nexpected: %q\nactual:   %q", c.text, c.content, content)
+		}
+	}
+}
+
+func TestParseDirectivesInvalid(t *testing.T) {
+	_, _, err := ParseDirectives("%% kroki: mermaid\ngraph TD\n")
+	expected := "invalid directive: mermaid, expected key=value"
+	if err == nil || err.Error() != expected {
+		t.Errorf("ParseDirectives error\nexpected: %s\nactual:   %v", expected, err)
+	}
+}
+
+func TestMergeOptions(t *testing.T) {
+	directives := Directives{Options: map[string]string{"theme": "dark"}}
+	result := directives.MergeOptions(map[string]string{"theme": "default", "scale": "2"})
+	expected := map[string]string{"theme": "dark", "scale": "2"}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("MergeOptions error\nexpected: %v\nactual:   %v", expected, result)
+	}
+}
@@ -0,0 +1,339 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuzutech/kroki-go"
+)
+
+// EmbeddedSource is the diagram source embedded in an image, the source is encoded in deflate + base64 format (kroki.CreatePayload)
+type EmbeddedSource struct {
+	DiagramType kroki.DiagramType
+	// Version is the version of the CLI that rendered the image
+	Version string
+	Payload string
+}
+
+// keys used to store the embedded source: PNG tEXt keywords, SVG attributes and PDF info entries
+const (
+	embedSourceKey  = "kroki:source"
+	embedTypeKey    = "kroki:type"
+	embedVersionKey = "kroki:version"
+	embedNamespace  = "https://kroki.io"
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	pdfSignature = []byte("%PDF-")
+)
+
+// ErrNoEmbeddedSource is returned when the image does not contain an embedded source
+var ErrNoEmbeddedSource = errors.New("the image does not contain an embedded diagram source, the image must be converted using --embed-source")
+
+// NewEmbeddedSource encodes the diagram source
+func NewEmbeddedSource(source string, diagramType kroki.DiagramType) (EmbeddedSource, error) {
+	payload, err := kroki.CreatePayload(source)
+	if err != nil {
+		return EmbeddedSource{}, fmt.Errorf("fail to encode the diagram source: %w", err)
+	}
+	return EmbeddedSource{DiagramType: diagramType, Version: gVersion, Payload: payload}, nil
+}
+
+// EmbedSource writes the embedded source in the image: a PNG tEXt chunk, an SVG metadata element or a PDF info dictionary,
+// the image is returned as is for the other formats
+func EmbedSource(image []byte, imageFormat kroki.ImageFormat, embedded EmbeddedSource) ([]byte, error) {
+	switch imageFormat {
+	case kroki.PNG:
+		return embedPNG(image, embedded)
+	case kroki.SVG:
+		return embedSVG(image, embedded)
+	case kroki.PDF:
+		return embedPDF(image, embedded)
+	}
+	return image, nil
+}
+
+// ExtractSource returns the source embedded in a PNG, SVG or PDF image
+func ExtractSource(image []byte) (EmbeddedSource, error) {
+	switch {
+	case bytes.HasPrefix(image, pngSignature):
+		return extractPNG(image)
+	case bytes.HasPrefix(image, pdfSignature):
+		return extractPDF(image)
+	case isSVG(image):
+		return extractSVG(image)
+	}
+	return EmbeddedSource{}, fmt.Errorf("unsupported image, expected one of: png, svg, pdf")
+}
+
+// IsImage returns true if the content is a PNG, SVG or PDF image
+func IsImage(content []byte) bool {
+	return bytes.HasPrefix(content, pngSignature) || bytes.HasPrefix(content, pdfSignature) || isSVG(content)
+}
+
+var svgStartPattern = regexp.MustCompile(`^\s*(<\?xml[^>]*>\s*)?(<!--.*?-->\s*|<!DOCTYPE[^>]*>\s*)*<svg\b`)
+
+func isSVG(content []byte) bool {
+	return svgStartPattern.Match(content)
+}
+
+func (e EmbeddedSource) entries() [][2]string {
+	entries := [][2]string{{embedSourceKey, e.Payload}, {embedTypeKey, string(e.DiagramType)}}
+	if e.Version != "" {
+		entries = append(entries, [2]string{embedVersionKey, e.Version})
+	}
+	return entries
+}
+
+func (e *EmbeddedSource) set(key string, value string) {
+	switch key {
+	case embedSourceKey:
+		e.Payload = value
+	case embedTypeKey:
+		e.DiagramType = kroki.DiagramType(value)
+	case embedVersionKey:
+		e.Version = value
+	}
+}
+
+// embedPNG inserts a tEXt chunk for each entry before the IEND chunk
+func embedPNG(image []byte, embedded EmbeddedSource) ([]byte, error) {
+	if !bytes.HasPrefix(image, pngSignature) || len(image) < len(pngSignature)+12 {
+		return image, fmt.Errorf("fail to embed the diagram source: invalid PNG image")
+	}
+	// the IEND chunk is the last 12 bytes: length (0), type and CRC
+	end := len(image) - 12
+	if string(image[end+4:end+8]) != "IEND" {
+		return image, fmt.Errorf("fail to embed the diagram source: invalid PNG image, IEND chunk not found")
+	}
+	var result bytes.Buffer
+	result.Write(image[:end])
+	for _, entry := range embedded.entries() {
+		data := append([]byte(entry[0]+"\x00"), entry[1]...)
+		chunk := append([]byte("tEXt"), data...)
+		_ = binary.Write(&result, binary.BigEndian, uint32(len(data)))
+		result.Write(chunk)
+		_ = binary.Write(&result, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	}
+	result.Write(image[end:])
+	return result.Bytes(), nil
+}
+
+func extractPNG(image []byte) (EmbeddedSource, error) {
+	var embedded EmbeddedSource
+	offset := len(pngSignature)
+	for offset+8 <= len(image) {
+		length := int(binary.BigEndian.Uint32(image[offset:]))
+		chunkType := string(image[offset+4 : offset+8])
+		if offset+12+length > len(image) {
+			return embedded, fmt.Errorf("invalid PNG image, truncated %s chunk", chunkType)
+		}
+		data := image[offset+8 : offset+8+length]
+		if chunkType == "tEXt" {
+			if i := bytes.IndexByte(data, 0); i > 0 {
+				embedded.set(string(data[:i]), string(data[i+1:]))
+			}
+		}
+		if chunkType == "IEND" {
+			break
+		}
+		offset += 12 + length
+	}
+	if embedded.Payload == "" {
+		return embedded, ErrNoEmbeddedSource
+	}
+	return embedded, nil
+}
+
+var (
+	svgEmbedPattern          = regexp.MustCompile(`<kroki:diagram\b[^>]*>`)
+	svgEmbedAttributePattern = regexp.MustCompile(`\b(kroki:\w+)="([^"]*)"`)
+)
+
+// embedSVG inserts a metadata element as the first child of the root element
+func embedSVG(image []byte, embedded EmbeddedSource) ([]byte, error) {
+	svg := string(image)
+	location := svgRootPattern.FindStringIndex(svg)
+	if location == nil {
+		return image, fmt.Errorf("fail to embed the diagram source: invalid SVG image, root element not found")
+	}
+	element := `<metadata><kroki:diagram xmlns:kroki="` + embedNamespace + `"`
+	for _, entry := range embedded.entries() {
+		element += ` ` + entry[0] + `="` + html.EscapeString(entry[1]) + `"`
+	}
+	element += `/></metadata>`
+	return []byte(svg[:location[1]] + element + svg[location[1]:]), nil
+}
+
+func extractSVG(image []byte) (EmbeddedSource, error) {
+	var embedded EmbeddedSource
+	element := svgEmbedPattern.Find(image)
+	if element == nil {
+		return embedded, ErrNoEmbeddedSource
+	}
+	for _, match := range svgEmbedAttributePattern.FindAllSubmatch(element, -1) {
+		embedded.set(string(match[1]), html.UnescapeString(string(match[2])))
+	}
+	if embedded.Payload == "" {
+		return embedded, ErrNoEmbeddedSource
+	}
+	return embedded, nil
+}
+
+var (
+	pdfStartXrefPattern = regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`)
+	pdfRootPattern      = regexp.MustCompile(`/Root\s+(\d+\s+\d+\s+R)`)
+	pdfSizePattern      = regexp.MustCompile(`/Size\s+(\d+)`)
+	pdfInfoPattern      = regexp.MustCompile(`/Kroki(Source|Type|Version)\s*\(([^)]*)\)`)
+	pdfInfoRefPattern   = regexp.MustCompile(`/Info\s+(\d+)\s+(\d+)\s+R`)
+)
+
+// pdfInfoNames contains the names of the PDF info entries, for instance: /KrokiSource
+var pdfInfoNames = map[string]string{embedSourceKey: "KrokiSource", embedTypeKey: "KrokiType", embedVersionKey: "KrokiVersion"}
+
+// embedPDF appends an incremental update which defines a new info dictionary containing the entries of the previous one,
+// the existing objects are left untouched
+// only the documents using a cross-reference table are supported, not the cross-reference streams (PDF 1.5+)
+func embedPDF(image []byte, embedded EmbeddedSource) ([]byte, error) {
+	startXref := pdfStartXrefPattern.FindSubmatch(image)
+	if startXref == nil {
+		return image, fmt.Errorf("fail to embed the diagram source: invalid PDF image, trailer not found")
+	}
+	xrefOffset, _ := strconv.Atoi(string(startXref[1]))
+	// the trailer of the cross-reference section that startxref points to describes the latest revision
+	trailer, err := pdfTrailer(image, xrefOffset)
+	if err != nil {
+		return image, fmt.Errorf("fail to embed the diagram source: %w", err)
+	}
+	root := pdfRootPattern.FindSubmatch(trailer)
+	size := pdfSizePattern.FindSubmatch(trailer)
+	if root == nil || size == nil {
+		return image, fmt.Errorf("fail to embed the diagram source: invalid PDF image, trailer not found")
+	}
+	objectNumber, _ := strconv.Atoi(string(size[1]))
+	info, err := pdfInfoEntries(image, trailer)
+	if err != nil {
+		return image, fmt.Errorf("fail to embed the diagram source: %w", err)
+	}
+	var result bytes.Buffer
+	result.Write(image)
+	if !bytes.HasSuffix(image, []byte("\n")) {
+		result.WriteString("\n")
+	}
+	offset := result.Len()
+	fmt.Fprintf(&result, "%d 0 obj\n<<", objectNumber)
+	if info != "" {
+		result.WriteString(" " + info)
+	}
+	for _, entry := range embedded.entries() {
+		// the payload, the diagram type and the version do not contain parentheses or backslashes
+		fmt.Fprintf(&result, " /%s (%s)", pdfInfoNames[entry[0]], entry[1])
+	}
+	result.WriteString(" >>\nendobj\n")
+	xref := result.Len()
+	fmt.Fprintf(&result, "xref\n%d 1\n%010d 00000 n \n", objectNumber, offset)
+	fmt.Fprintf(&result, "trailer\n<< /Size %d /Root %s /Info %d 0 R /Prev %s >>\n", objectNumber+1, root[1], objectNumber, startXref[1])
+	fmt.Fprintf(&result, "startxref\n%d\n%%%%EOF\n", xref)
+	return result.Bytes(), nil
+}
+
+// pdfTrailer returns the content of the trailer dictionary of the cross-reference table at the given offset
+func pdfTrailer(image []byte, xrefOffset int) ([]byte, error) {
+	if xrefOffset >= len(image) || !bytes.HasPrefix(bytes.TrimLeft(image[xrefOffset:], " \t\r\n"), []byte("xref")) {
+		return nil, errors.New("PDF images using a cross-reference stream are not supported")
+	}
+	// the cross-reference table only contains numbers, the first trailer keyword follows it
+	trailer := bytes.Index(image[xrefOffset:], []byte("trailer"))
+	if trailer < 0 {
+		return nil, errors.New("invalid PDF image, trailer not found")
+	}
+	start := bytes.Index(image[xrefOffset+trailer:], []byte("<<"))
+	if start < 0 {
+		return nil, errors.New("invalid PDF image, trailer not found")
+	}
+	start += xrefOffset + trailer + 2
+	end, ok := pdfDictionaryEnd(image, start)
+	if !ok {
+		return nil, errors.New("invalid PDF image, trailer is not terminated")
+	}
+	return image[start:end], nil
+}
+
+// pdfInfoEntries returns the entries of the info dictionary referenced by the trailer (Title, Producer, CreationDate...),
+// without the embedded source entries, or an empty string when the document has no info dictionary
+func pdfInfoEntries(image []byte, trailer []byte) (string, error) {
+	ref := pdfInfoRefPattern.FindSubmatch(trailer)
+	if ref == nil {
+		return "", nil
+	}
+	// the latest definition of the object wins
+	definition := regexp.MustCompile(`(?:^|\s)` + string(ref[1]) + `\s+` + string(ref[2]) + `\s+obj\s*<<`)
+	matches := definition.FindAllIndex(image, -1)
+	if len(matches) == 0 {
+		return "", fmt.Errorf("invalid PDF image, info dictionary %s %s R not found", ref[1], ref[2])
+	}
+	start := matches[len(matches)-1][1]
+	end, ok := pdfDictionaryEnd(image, start)
+	if !ok {
+		return "", fmt.Errorf("invalid PDF image, info dictionary %s %s R is not terminated", ref[1], ref[2])
+	}
+	entries := pdfInfoPattern.ReplaceAll(image[start:end], nil)
+	return strings.TrimSpace(string(entries)), nil
+}
+
+// pdfDictionaryEnd returns the position of the >> that closes the dictionary whose content starts at the given position,
+// the nested dictionaries, the literal strings (which can contain balanced or escaped parentheses) and the hexadecimal strings are skipped
+func pdfDictionaryEnd(data []byte, start int) (int, bool) {
+	depth := 1
+	for i := start; i < len(data); i++ {
+		switch {
+		case data[i] == '(':
+			nested := 1
+			for i++; i < len(data) && nested > 0; i++ {
+				switch data[i] {
+				case '\\':
+					i++
+				case '(':
+					nested++
+				case ')':
+					nested--
+				}
+			}
+			i--
+		case bytes.HasPrefix(data[i:], []byte("<<")):
+			depth++
+			i++
+		case bytes.HasPrefix(data[i:], []byte(">>")):
+			depth--
+			if depth == 0 {
+				return i, true
+			}
+			i++
+		case data[i] == '<':
+			// hexadecimal string
+			for i < len(data) && data[i] != '>' {
+				i++
+			}
+		}
+	}
+	return 0, false
+}
+
+func extractPDF(image []byte) (EmbeddedSource, error) {
+	var embedded EmbeddedSource
+	for _, match := range pdfInfoPattern.FindAllSubmatch(image, -1) {
+		embedded.set("kroki:"+strings.ToLower(string(match[1])), string(match[2]))
+	}
+	if embedded.Payload == "" {
+		return embedded, ErrNoEmbeddedSource
+	}
+	return embedded, nil
+}
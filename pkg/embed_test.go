@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yuzutech/kroki-go"
+)
+
+const minimalPDF = `%PDF-1.4
+1 0 obj
+<< /Type /Catalog /Pages 2 0 R >>
+endobj
+2 0 obj
+<< /Type /Pages /Kids [] /Count 0 >>
+endobj
+xref
+0 3
+0000000000 65535 f 
+0000000009 00000 n 
+0000000058 00000 n 
+trailer
+<< /Size 3 /Root 1 0 R >>
+startxref
+110
+%%EOF
+`
+
+func testPNG(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestEmbedSource(t *testing.T) {
+	source := "digraph G {Hello->World}\n"
+	embedded, err := NewEmbeddedSource(source, kroki.GraphViz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded.Version = "1.0.0"
+	cases := []struct {
+		imageFormat kroki.ImageFormat
+		image       []byte
+	}{
+		{imageFormat: kroki.PNG, image: testPNG(t)},
+		{imageFormat: kroki.SVG, image: []byte(`<?xml version="1.0"?>` + "\n" + `<svg xmlns="http://www.w3.org/2000/svg"><g/></svg>`)},
+		{imageFormat: kroki.PDF, image: []byte(minimalPDF)},
+	}
+	for _, c := range cases {
+		result, err := EmbedSource(c.image, c.imageFormat, embedded)
+		if err != nil {
+			t.Errorf("EmbedSource(%s) error: %v", c.imageFormat, err)
+			continue
+		}
+		extracted, err := ExtractSource(result)
+		if err != nil {
+			t.Errorf("ExtractSource(%s) error: %v", c.imageFormat, err)
+			continue
+		}
+		if extracted != embedded {
+			t.Errorf("ExtractSource(%s) error\nexpected: %+v\nactual:   %+v", c.imageFormat, embedded, extracted)
+		}
+		decoded, err := DecodeImage(result)
+		if err != nil || decoded != source {
+			t.Errorf("DecodeImage(%s) error\nexpected: %s\nactual:   %s (%v)", c.imageFormat, source, decoded, err)
+		}
+	}
+}
+
+func TestEmbedSourcePNGIsValid(t *testing.T) {
+	embedded, _ := NewEmbeddedSource("graph { a -- b }", kroki.GraphViz)
+	result, err := EmbedSource(testPNG(t), kroki.PNG, embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = png.Decode(bytes.NewReader(result))
+	if err != nil {
+		t.Errorf("png.Decode error: %v", err)
+	}
+}
+
+func TestEmbedSourcePDFIsIncremental(t *testing.T) {
+	embedded, _ := NewEmbeddedSource("graph { a -- b }", kroki.GraphViz)
+	result, err := EmbedSource([]byte(minimalPDF), kroki.PDF, embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(result), minimalPDF) {
+		t.Errorf("EmbedSource(pdf) error: the original content must be left untouched")
+	}
+	expected := "/Size 4 /Root 1 0 R /Info 3 0 R /Prev 110"
+	if !strings.Contains(string(result), expected) {
+		t.Errorf("EmbedSource(pdf) error\nexpected: %s\nactual:   %s", expected, result[len(minimalPDF):])
+	}
+}
+
+func TestExtractSourceWithoutEmbeddedSource(t *testing.T) {
+	_, err := ExtractSource(testPNG(t))
+	if !errors.Is(err, ErrNoEmbeddedSource) {
+		t.Errorf("ExtractSource error\nexpected: %v\nactual:   %v", ErrNoEmbeddedSource, err)
+	}
+	_, err = ExtractSource([]byte("GIF89a"))
+	expected := "unsupported image, expected one of: png, svg, pdf"
+	if err == nil || err.Error() != expected {
+		t.Errorf("ExtractSource error\nexpected: %s\nactual:   %v", expected, err)
+	}
+}
+
+func TestDecodeInputFromImageFile(t *testing.T) {
+	embedded, _ := NewEmbeddedSource("digraph G {Hello->World}", kroki.GraphViz)
+	image, err := EmbedSource(testPNG(t), kroki.PNG, embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "hello.png")
+	err = os.WriteFile(file, image, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := DecodeInput(file)
+	if err != nil || result != "digraph G {Hello->World}" {
+		t.Errorf("DecodeInput error\nexpected: %s\nactual:   %s (%v)", "digraph G {Hello->World}", result, err)
+	}
+}
+
+func TestDecodeInputPayloadTakesPrecedenceOverFile(t *testing.T) {
+	embedded, _ := NewEmbeddedSource("graph { a -- b }", kroki.GraphViz)
+	image, err := EmbedSource(testPNG(t), kroki.PNG, embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := "eNpKyUwvSizIUHBXqPZIzcnJ17ULzy_KSakFDABsQAjG"
+	// an image file named like the payload in the working directory
+	workingDir, _ := os.Getwd()
+	defer func() { _ = os.Chdir(workingDir) }()
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(payload, image, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := DecodeInput(payload)
+	if err != nil || result != "digraph G {Hello->World}" {
+		t.Errorf("DecodeInput error\nexpected: %s\nactual:   %s (%v)", "digraph G {Hello->World}", result, err)
+	}
+}
+
+func TestEmbedSourcePDFKeepsInfo(t *testing.T) {
+	info := "3 0 obj\n<< /Title (Login \\(v2\\)) /Producer <4B726F6B69> /CreationDate (D:20260101000000Z) >>\nendobj\n"
+	body := minimalPDF[:strings.Index(minimalPDF, "xref")] + info
+	document := body + "xref\n0 4\n0000000000 65535 f \n0000000009 00000 n \n0000000058 00000 n \n0000000110 00000 n \n" +
+		"trailer\n<< /Size 4 /Root 1 0 R /Info 3 0 R >>\nstartxref\n" + strconv.Itoa(len(body)) + "\n%%EOF\n"
+	embedded, _ := NewEmbeddedSource("graph { a -- b }", kroki.GraphViz)
+	result, err := EmbedSource([]byte(document), kroki.PDF, embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "4 0 obj\n<< /Title (Login \\(v2\\)) /Producer <4B726F6B69> /CreationDate (D:20260101000000Z) /KrokiSource ("
+	if !strings.Contains(string(result), expected) {
+		t.Errorf("EmbedSource(pdf) error\nexpected: %s\nactual:   %s", expected, result[len(document):])
+	}
+	// the source can be embedded again, the previous entries are replaced
+	result, err = EmbedSource(result, kroki.PDF, embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(result[strings.LastIndex(string(result), " obj\n"):]), "/KrokiSource") != 1 {
+		t.Errorf("EmbedSource(pdf) error: the embedded source entries must not be duplicated")
+	}
+	extracted, err := ExtractSource(result)
+	if err != nil || extracted.Payload != embedded.Payload {
+		t.Errorf("ExtractSource(pdf) error\nexpected: %s\nactual:   %s (%v)", embedded.Payload, extracted.Payload, err)
+	}
+}
+
+func TestEmbedSourcePDFIncrementalUpdate(t *testing.T) {
+	// the update adds a stream containing trailer keys and a comment after the trailer, only the trailer at startxref must be read
+	stream := "trailer << /Size 99 /Root 9 0 R >>"
+	object := "3 0 obj\n<< /Length " + strconv.Itoa(len(stream)) + " >>\nstream\n" + stream + "\nendstream\nendobj\n"
+	body := minimalPDF + object
+	document := body + "xref\n3 1\n" + fmt.Sprintf("%010d", len(minimalPDF)) + " 00000 n \n" +
+		"trailer\n<< /Size 4 /Root 1 0 R /Prev 110 >>\n% /Size 99 /Root 9 0 R\nstartxref\n" + strconv.Itoa(len(body)) + "\n%%EOF\n"
+	embedded, _ := NewEmbeddedSource("graph { a -- b }", kroki.GraphViz)
+	result, err := EmbedSource([]byte(document), kroki.PDF, embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := string(result[len(document):])
+	for _, expected := range []string{"4 0 obj\n<< /KrokiSource (", "xref\n4 1\n", "/Size 5 /Root 1 0 R /Info 4 0 R /Prev " + strconv.Itoa(len(body))} {
+		if !strings.Contains(update, expected) {
+			t.Errorf("EmbedSource(pdf) error\nexpected: %s\nactual:   %s", expected, update)
+		}
+	}
+	source, err := DecodeImage(result)
+	if err != nil || source != "graph { a -- b }" {
+		t.Errorf("DecodeImage(pdf) error\nexpected: %s\nactual:   %s (%v)", "graph { a -- b }", source, err)
+	}
+}
+
+func TestEmbedSourcePDFCrossReferenceStream(t *testing.T) {
+	document := "%PDF-1.5\n1 0 obj\n<< /Type /XRef /Size 2 /Root 2 0 R /W [1 2 1] >>\nstream\nendstream\nendobj\nstartxref\n9\n%%EOF\n"
+	embedded, _ := NewEmbeddedSource("graph { a -- b }", kroki.GraphViz)
+	_, err := EmbedSource([]byte(document), kroki.PDF, embedded)
+	expected := "fail to embed the diagram source: PDF images using a cross-reference stream are not supported"
+	if err == nil || err.Error() != expected {
+		t.Errorf("EmbedSource(pdf) error\nexpected: %s\nactual:   %v", expected, err)
+	}
+}
@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
+
+// Hook is an external command that transforms the diagram source (pre) or the image (post),
+// the input is written to the standard input and the result is read from the standard output
+type Hook struct {
+	// Command is executed using the shell (sh -c or cmd /C on Windows)
+	Command string `mapstructure:"command"`
+	// Types contains diagram types or glob patterns (for instance: vega*), the hook applies to all diagram types when empty
+	Types []string `mapstructure:"types"`
+	// Timeout overrides hooks.timeout
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// HookContext describes the conversion, it is passed to the hooks using environment variables
+type HookContext struct {
+	DiagramType kroki.DiagramType
+	ImageFormat kroki.ImageFormat
+	// InputFile is empty when the diagram is read from stdin
+	InputFile string
+	// OutputFile is empty when the image is written to stdout
+	OutputFile string
+}
+
+// Matches returns true if the hook applies to the diagram type
+func (h Hook) Matches(diagramType kroki.DiagramType) bool {
+	return len(h.Types) == 0 || matchDiagramType(h.Types, diagramType)
+}
+
+// GetHooks returns the hooks of the given stage (pre or post) defined in the configuration,
+// no hooks are returned when they are disabled (no_hooks)
+func GetHooks(stage string) ([]Hook, error) {
+	if viper.GetBool("no_hooks") {
+		return nil, nil
+	}
+	var hooks []Hook
+	err := viper.UnmarshalKey("hooks."+stage, &hooks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hooks.%s: %w", stage, err)
+	}
+	for i, hook := range hooks {
+		if strings.TrimSpace(hook.Command) == "" {
+			return nil, fmt.Errorf("invalid hooks.%s #%d: command must not be empty", stage, i+1)
+		}
+		if hook.Timeout == 0 {
+			hooks[i].Timeout = viper.GetDuration("hooks.timeout")
+		}
+	}
+	return hooks, nil
+}
+
+// RunHooks pipes the input through the hooks matching the diagram type, in the order of definition
+func RunHooks(stage string, input []byte, hookContext HookContext) ([]byte, error) {
+	hooks, err := GetHooks(stage)
+	if err != nil {
+		return input, err
+	}
+	for _, hook := range hooks {
+		if !hook.Matches(hookContext.DiagramType) {
+			continue
+		}
+		input, err = runHook(hook, input, hookContext)
+		if err != nil {
+			return input, fmt.Errorf("%s hook failed: %w", stage, err)
+		}
+	}
+	return input, nil
+}
+
+func runHook(hook Hook, input []byte, hookContext HookContext) ([]byte, error) {
+	ctx := context.Background()
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		defer cancel()
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	}
+	cmd.Env = append(os.Environ(),
+		"KROKI_DIAGRAM_TYPE="+string(hookContext.DiagramType),
+		"KROKI_IMAGE_FORMAT="+string(hookContext.ImageFormat),
+		"KROKI_INPUT_FILE="+hookContext.InputFile,
+		"KROKI_OUTPUT_FILE="+hookContext.OutputFile,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Start()
+	if err != nil {
+		return input, fmt.Errorf("%s: %w", hook.Command, err)
+	}
+	// the processes started by the shell can keep the output open after the shell is killed, do not wait for them
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return input, fmt.Errorf("%s: timed out after %s", hook.Command, hook.Timeout)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			message := fmt.Sprintf("%s: exit status %d", hook.Command, exitErr.ExitCode())
+			if output := strings.TrimSpace(stderr.String()); output != "" {
+				message += ": " + output
+			}
+			return input, errors.New(message)
+		}
+		return input, fmt.Errorf("%s: %w", hook.Command, err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
+
+func TestRunHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks use POSIX commands")
+	}
+	loadTestConfig(t, `
+hooks:
+  pre:
+    - command: tr a-z A-Z
+      types: [graphviz]
+    - command: 'sed "s/$/ # $KROKI_DIAGRAM_TYPE $KROKI_IMAGE_FORMAT/"'
+  post:
+    - command: cat; printf '<!-- %s -->' "$KROKI_OUTPUT_FILE"
+      types: [vega*]
+`)
+	hookContext := HookContext{DiagramType: kroki.GraphViz, ImageFormat: kroki.SVG, OutputFile: "out.svg"}
+	result, err := RunHooks("pre", []byte("digraph { a -> b }"), hookContext)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := "DIGRAPH { A -> B } # graphviz svg"
+	if string(result) != expected {
+		t.Errorf("RunHooks error\nexpected: %s\nactual:   %s", expected, string(result))
+	}
+	// the post hook only applies to vega and vegalite
+	result, _ = RunHooks("post", []byte("<svg/>"), hookContext)
+	if string(result) != "<svg/>" {
+		t.Errorf("RunHooks error\nexpected: %s\nactual:   %s", "<svg/>", string(result))
+	}
+	hookContext.DiagramType = kroki.VegaLite
+	result, _ = RunHooks("post", []byte("<svg/>"), hookContext)
+	if string(result) != "<svg/><!-- out.svg -->" {
+		t.Errorf("RunHooks error\nexpected: %s\nactual:   %s", "<svg/><!-- out.svg -->", string(result))
+	}
+	viper.Set("no_hooks", true)
+	defer viper.Set("no_hooks", nil)
+	result, _ = RunHooks("post", []byte("<svg/>"), hookContext)
+	if string(result) != "<svg/>" {
+		t.Errorf("RunHooks error\nexpected: %s\nactual:   %s", "<svg/>", string(result))
+	}
+}
+
+func TestRunHooksErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks use POSIX commands")
+	}
+	cases := []struct {
+		hook     Hook
+		expected string
+	}{
+		{hook: Hook{Command: "echo invalid state >&2; exit 3"}, expected: "echo invalid state >&2; exit 3: exit status 3: invalid state"},
+		{hook: Hook{Command: "sleep 5", Timeout: 50 * time.Millisecond}, expected: "sleep 5: timed out after 50ms"},
+	}
+	for _, c := range cases {
+		_, err := runHook(c.hook, nil, HookContext{})
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("runHook(%s) error\nexpected: %s\nactual:   %v", c.hook.Command, c.expected, err)
+		}
+	}
+	loadTestConfig(t, "hooks:\n  pre:\n    - types: [mermaid]\n")
+	_, err := GetHooks("pre")
+	expected := "invalid hooks.pre #1: command must not be empty"
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("GetHooks error\nexpected: %s\nactual:   %v", expected, err)
+	}
+}
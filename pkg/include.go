@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	includePattern  = regexp.MustCompile(`^\s*!(include|include_many|include_once|includesub)\s+(.+?)\s*$`)
+	startSubPattern = regexp.MustCompile(`^\s*!startsub\s+(\S+)\s*$`)
+	endSubPattern   = regexp.MustCompile(`^\s*!endsub\b`)
+	blockPattern    = regexp.MustCompile(`^\s*@(start|end)\w+`)
+)
+
+// IncludePaths returns the directories searched for the included files (include_paths),
+// the environment variable KROKI_INCLUDE_PATHS is a comma-separated list
+func IncludePaths() []string {
+	var result []string
+	for _, value := range viper.GetStringSlice("include_paths") {
+		for _, includePath := range strings.Split(value, ",") {
+			if includePath = strings.TrimSpace(includePath); includePath != "" {
+				result = append(result, includePath)
+			}
+		}
+	}
+	return result
+}
+
+// plantUMLIncluder inlines the local PlantUML includes
+type plantUMLIncluder struct {
+	*sourceResolver
+	included map[string]bool
+}
+
+// ResolvePlantUMLIncludes inlines the !include, !include_many, !include_once and !includesub directives
+// relative to the directory of the source file (or the search paths), standard library (<C4/C4_Context>) and URL includes are left untouched
+// it returns the content and the included files
+func ResolvePlantUMLIncludes(text string, filePath string, searchPaths []string) (string, []string, error) {
+	resolver, err := newSourceResolver(filePath, searchPaths)
+	if err != nil {
+		return text, nil, err
+	}
+	includer := &plantUMLIncluder{sourceResolver: resolver, included: map[string]bool{}}
+	result, err := includer.resolve(text, baseDir(filePath))
+	return result, includer.files, err
+}
+
+func (i *plantUMLIncluder) resolve(text string, dir string) (string, error) {
+	lines := strings.Split(text, "\n")
+	for n, line := range lines {
+		match := includePattern.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil {
+			continue
+		}
+		directive, target := match[1], strings.Trim(match[2], `"`)
+		if strings.HasPrefix(target, "<") || strings.Contains(target, "://") {
+			continue
+		}
+		// the block or the sub-part to include, for instance: !include file.puml!1 or !includesub file.puml!BASIC
+		selector := ""
+		if index := strings.LastIndex(target, "!"); index > 0 {
+			target, selector = target[:index], target[index+1:]
+		}
+		file, err := i.find(target, dir)
+		if err != nil {
+			return text, err
+		}
+		if i.included[file] && directive != "include_many" && directive != "includesub" && !i.inStack(file) {
+			lines[n] = ""
+			continue
+		}
+		content, err := i.enter(file)
+		if err != nil {
+			return text, err
+		}
+		var included string
+		if directive == "includesub" {
+			included, err = extractSub(content, selector)
+		} else {
+			included, err = extractBlock(content, selector)
+		}
+		if err != nil {
+			i.leave()
+			return text, fmt.Errorf("%s: %w", file, err)
+		}
+		i.included[file] = true
+		included, err = i.resolve(included, filepath.Dir(file))
+		i.leave()
+		if err != nil {
+			return text, err
+		}
+		lines[n] = strings.TrimSuffix(included, "\n")
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// extractBlock returns the content of the file without the @startuml/@enduml lines,
+// when the file contains multiple blocks, the selector is the position (starting at 0) or the id of the block
+func extractBlock(content string, selector string) (string, error) {
+	var blocks [][]string
+	var ids []string
+	var current []string
+	inside := false
+	for _, line := range strings.Split(content, "\n") {
+		if match := blockPattern.FindStringSubmatch(line); match != nil {
+			if match[1] == "start" {
+				inside = true
+				current = nil
+				id := ""
+				if start := startPattern.FindStringSubmatch(line); start != nil {
+					id = start[3]
+					if id == "" {
+						id = strings.Trim(strings.TrimSpace(start[2]), `"`)
+					}
+				}
+				ids = append(ids, id)
+			} else if inside {
+				inside = false
+				blocks = append(blocks, current)
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(blocks) == 0 {
+		// the file does not contain @startuml, the whole content is included
+		if selector != "" {
+			return "", fmt.Errorf("unable to find the block %s", selector)
+		}
+		return content, nil
+	}
+	if selector == "" {
+		return strings.Join(blocks[0], "\n"), nil
+	}
+	if index, err := strconv.Atoi(selector); err == nil && index >= 0 && index < len(blocks) {
+		return strings.Join(blocks[index], "\n"), nil
+	}
+	for index, id := range ids {
+		if id == selector && index < len(blocks) {
+			return strings.Join(blocks[index], "\n"), nil
+		}
+	}
+	return "", fmt.Errorf("unable to find the block %s", selector)
+}
+
+// extractSub returns the lines between !startsub NAME and !endsub, a sub-part can be defined multiple times
+func extractSub(content string, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("the sub-part name is missing, expected: !includesub file!NAME")
+	}
+	var result []string
+	found, inside := false, false
+	for _, line := range strings.Split(content, "\n") {
+		if match := startSubPattern.FindStringSubmatch(line); match != nil {
+			inside = match[1] == name
+			found = found || inside
+			continue
+		}
+		if endSubPattern.MatchString(line) {
+			inside = false
+			continue
+		}
+		if inside {
+			result = append(result, line)
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("unable to find the sub-part %s", name)
+	}
+	return strings.Join(result, "\n"), nil
+}
+
+// WriteDepfile writes a Makefile rule with the output files as targets and the source and included files as prerequisites
+func WriteDepfile(depfile string, targets []string, prerequisites []string) error {
+	escape := func(paths []string) string {
+		escaped := make([]string, len(paths))
+		for i, p := range paths {
+			escaped[i] = strings.ReplaceAll(p, " ", `\ `)
+		}
+		return strings.Join(escaped, " ")
+	}
+	content := escape(targets) + ": " + escape(prerequisites) + "\n"
+	err := os.WriteFile(depfile, []byte(content), 0644)
+	if err != nil {
+		return fmt.Errorf("fail to write file %s: %w", depfile, err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolvePlantUMLIncludes(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "shared")
+	writeFile(t, filepath.Join(dir, "common", "skin.iuml"), "@startuml\nskinparam monochrome true\n!include_once ../shared/colors.iuml\n@enduml\n")
+	writeFile(t, filepath.Join(shared, "colors.iuml"), "!$primary = \"#336699\"\n")
+	writeFile(t, filepath.Join(shared, "actors.iuml"), "!startsub USERS\nactor Alice\n!endsub\n!startsub ADMINS\nactor Root\n!endsub\n")
+	writeFile(t, filepath.Join(shared, "blocks.puml"), "@startuml(id=first)\nBob -> Alice\n@enduml\n@startuml(id=second)\nAlice -> Bob\n@enduml\n")
+	source := filepath.Join(dir, "docs", "login.puml")
+	writeFile(t, source, `@startuml
+!include ../common/skin.iuml
+!include colors.iuml
+!include <C4/C4_Context>
+!includesub actors.iuml!USERS
+!include blocks.puml!second
+!include_many blocks.puml!0
+@enduml
+`)
+	content, _ := os.ReadFile(source)
+	result, files, err := ResolvePlantUMLIncludes(string(content), source, []string{shared})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := `@startuml
+skinparam monochrome true
+!$primary = "#336699"
+
+!include <C4/C4_Context>
+actor Alice
+Alice -> Bob
+Bob -> Alice
+@enduml
+`
+	if result != expected {
+		t.Errorf("ResolvePlantUMLIncludes error\nexpected: %s\nactual:   %s", expected, result)
+	}
+	expectedFiles := []string{
+		filepath.Join(dir, "common", "skin.iuml"),
+		filepath.Join(shared, "colors.iuml"),
+		filepath.Join(shared, "actors.iuml"),
+		filepath.Join(shared, "blocks.puml"),
+	}
+	if strings.Join(files, "\n") != strings.Join(expectedFiles, "\n") {
+		t.Errorf("ResolvePlantUMLIncludes error\nexpected: %v\nactual:   %v", expectedFiles, files)
+	}
+}
+
+func TestResolvePlantUMLIncludesErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.iuml"), "!include b.iuml\n")
+	writeFile(t, filepath.Join(dir, "b.iuml"), "!include a.iuml\n")
+	writeFile(t, filepath.Join(dir, "subs.iuml"), "!startsub USERS\nactor Alice\n!endsub\n")
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{
+			text:     "!include a.iuml",
+			expected: "include cycle: " + strings.Join([]string{filepath.Join(dir, "main.puml"), filepath.Join(dir, "a.iuml"), filepath.Join(dir, "b.iuml"), filepath.Join(dir, "a.iuml")}, " -> "),
+		},
+		{
+			text:     "!include missing.iuml",
+			expected: "unable to resolve missing.iuml, file not found in: " + dir,
+		},
+		{
+			text:     "!includesub subs.iuml!ADMINS",
+			expected: filepath.Join(dir, "subs.iuml") + ": unable to find the sub-part ADMINS",
+		},
+	}
+	for _, c := range cases {
+		_, _, err := ResolvePlantUMLIncludes(c.text, filepath.Join(dir, "main.puml"), nil)
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("ResolvePlantUMLIncludes(%s) error\nexpected: %s\nactual:   %v", c.text, c.expected, err)
+		}
+	}
+}
+
+func TestWriteDepfile(t *testing.T) {
+	depfile := filepath.Join(t.TempDir(), "login.d")
+	err := WriteDepfile(depfile, []string{"out/login.svg"}, []string{"docs/login.puml", "common/my skin.iuml"})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	content, _ := os.ReadFile(depfile)
+	expected := "out/login.svg: docs/login.puml common/my\\ skin.iuml\n"
+	if string(content) != expected {
+		t.Errorf("WriteDepfile error\nexpected: %s\nactual:   %s", expected, string(content))
+	}
+}
@@ -0,0 +1,63 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// scriptRequest is the JSON body of POST /_mock/responses
+type scriptRequest struct {
+	Match       Match  `json:"match"`
+	StatusCode  int    `json:"status"`
+	Body        string `json:"body"`
+	ContentType string `json:"content_type"`
+	// Delay is a duration, for instance: 500ms
+	Delay string `json:"delay"`
+	// Times is the number of matching requests the response applies to, all of them when 0
+	Times int `json:"times"`
+}
+
+// serveControl exposes the server over HTTP, for the tests written in other languages:
+//
+//	GET  /_mock/requests   returns the recorded requests (JSON)
+//	POST /_mock/responses  scripts a response (JSON: match, status, body, content_type, delay and times)
+//	POST /_mock/reset      removes the recorded requests and the scripted responses
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == ControlPrefix+"requests" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		requests := s.Requests()
+		if requests == nil {
+			requests = []Request{}
+		}
+		_ = json.NewEncoder(w).Encode(requests)
+	case r.URL.Path == ControlPrefix+"responses" && r.Method == http.MethodPost:
+		var script scriptRequest
+		if err := json.NewDecoder(r.Body).Decode(&script); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON request: %v", err), http.StatusBadRequest)
+			return
+		}
+		response := Response{StatusCode: script.StatusCode, Body: script.Body, ContentType: script.ContentType}
+		if script.Delay != "" {
+			delay, err := time.ParseDuration(script.Delay)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid delay: %s, expected a duration (for instance: 500ms)", script.Delay), http.StatusBadRequest)
+				return
+			}
+			response.Delay = delay
+		}
+		times := script.Times
+		if times <= 0 {
+			times = -1
+		}
+		s.Script(script.Match, response, times)
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == ControlPrefix+"reset" && r.Method == http.MethodPost:
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
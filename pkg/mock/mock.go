@@ -0,0 +1,342 @@
+// Package mock provides a fake Kroki server to test the tools that use the Kroki HTTP API without a Kroki instance.
+//
+// The server decodes the diagrams sent using GET (deflate + base64 payload) and POST (plain text or JSON body) requests,
+// returns a deterministic placeholder image in the requested format and records the requests.
+// The responses can be scripted to return errors, status codes or delays:
+//
+//	server := mock.New()
+//	server.RespondOnce(mock.Match{DiagramType: "plantuml"}, mock.Response{StatusCode: 503})
+//	ts := httptest.NewServer(server)
+//	defer ts.Close()
+package mock
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ControlPrefix is the path prefix of the endpoints used to inspect and script the server over HTTP
+const ControlPrefix = "/_mock/"
+
+// optionHeaderPrefix is the prefix of the (canonical) headers that define a diagram option
+const optionHeaderPrefix = "Kroki-Diagram-Options-"
+
+// Request is a diagram conversion request received by the server
+type Request struct {
+	Method       string            `json:"method"`
+	Path         string            `json:"path"`
+	DiagramType  string            `json:"diagram_type"`
+	OutputFormat string            `json:"output_format"`
+	Source       string            `json:"source"`
+	Options      map[string]string `json:"options,omitempty"`
+	Header       http.Header       `json:"header"`
+}
+
+// Match selects the requests a scripted response applies to, empty fields match all requests
+type Match struct {
+	DiagramType  string `json:"diagram_type,omitempty"`
+	OutputFormat string `json:"output_format,omitempty"`
+	// Contains matches the requests whose diagram source contains the text
+	Contains string `json:"contains,omitempty"`
+}
+
+// Matches returns true if the request is selected
+func (m Match) Matches(request Request) bool {
+	return (m.DiagramType == "" || m.DiagramType == request.DiagramType) &&
+		(m.OutputFormat == "" || m.OutputFormat == request.OutputFormat) &&
+		(m.Contains == "" || strings.Contains(request.Source, m.Contains))
+}
+
+// Response is a scripted response, the placeholder image is returned when the status code is 200 (or 0) and the body is empty
+type Response struct {
+	StatusCode  int
+	Body        string
+	ContentType string
+	// Delay is applied before the response is sent
+	Delay time.Duration
+}
+
+// rule is a scripted response, times is the number of remaining uses (unlimited when 0 or negative)
+type rule struct {
+	match    Match
+	response Response
+	times    int
+}
+
+// Server is a fake Kroki server, it is safe for concurrent use
+type Server struct {
+	// Delay is applied to every response (in addition to the delay of a scripted response)
+	Delay time.Duration
+
+	mutex    sync.Mutex
+	requests []Request
+	rules    []*rule
+}
+
+// New returns a server which returns the placeholder images
+func New() *Server {
+	return &Server{}
+}
+
+// Respond returns the response for all the matching requests, the rules are evaluated in the order of definition
+func (s *Server) Respond(match Match, response Response) {
+	s.Script(match, response, -1)
+}
+
+// RespondOnce returns the response for the next matching request only, for instance to test the retries
+func (s *Server) RespondOnce(match Match, response Response) {
+	s.Script(match, response, 1)
+}
+
+// Script returns the response for the next n matching requests (all of them when n <= 0)
+func (s *Server) Script(match Match, response Response, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rules = append(s.rules, &rule{match: match, response: response, times: n})
+}
+
+// Requests returns the requests received by the server, in order
+func (s *Server) Requests() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// Reset removes the recorded requests and the scripted responses
+func (s *Server) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = nil
+	s.rules = nil
+}
+
+// record saves the request and returns the scripted response, if any
+func (s *Server) record(request Request) (Response, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, request)
+	for i, r := range s.rules {
+		if !r.match.Matches(request) {
+			continue
+		}
+		if r.times > 0 {
+			r.times--
+			if r.times == 0 {
+				s.rules = append(s.rules[:i:i], s.rules[i+1:]...)
+			}
+		}
+		return r.response, true
+	}
+	return Response{}, false
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, ControlPrefix) {
+		s.serveControl(w, r)
+		return
+	}
+	if r.URL.Path == "/health" {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"status":"pass"}`)
+		return
+	}
+	request, err := parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response, scripted := s.record(request)
+	delay := s.Delay + response.Delay
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if scripted && (response.Body != "" || (response.StatusCode != 0 && response.StatusCode != http.StatusOK)) {
+		statusCode := response.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+		contentType := response.ContentType
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(statusCode)
+		_, _ = io.WriteString(w, response.Body)
+		return
+	}
+	image, contentType, err := Placeholder(request.DiagramType, request.OutputFormat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(image)
+}
+
+// parseRequest decodes the diagram of a request: GET /{type}/{format}/{payload}, POST /{type}/{format} (plain text or JSON)
+// or POST / (JSON with the diagram type and the output format)
+func parseRequest(r *http.Request) (Request, error) {
+	request := Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Options: map[string]string{}}
+	// the options can be sent using headers (Kroki-Diagram-Options-Theme: dark) or query parameters (?theme=dark)
+	for name := range r.Header {
+		if strings.HasPrefix(name, optionHeaderPrefix) && len(name) > len(optionHeaderPrefix) {
+			request.Options[strings.ToLower(name[len(optionHeaderPrefix):])] = r.Header.Get(name)
+		}
+	}
+	for name := range r.URL.Query() {
+		request.Options[name] = r.URL.Query().Get(name)
+	}
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch r.Method {
+	case http.MethodGet:
+		if len(segments) != 3 {
+			return request, fmt.Errorf("invalid path %s, expected: /{type}/{format}/{payload}", r.URL.Path)
+		}
+		request.DiagramType, request.OutputFormat = segments[0], segments[1]
+		source, err := DecodePayload(segments[2])
+		if err != nil {
+			return request, err
+		}
+		request.Source = source
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return request, fmt.Errorf("fail to read the request: %w", err)
+		}
+		if len(segments) == 2 {
+			request.DiagramType, request.OutputFormat = segments[0], segments[1]
+		} else if r.URL.Path != "/" {
+			return request, fmt.Errorf("invalid path %s, expected: / or /{type}/{format}", r.URL.Path)
+		}
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			request.Source = string(body)
+			break
+		}
+		var payload struct {
+			DiagramSource  string            `json:"diagram_source"`
+			DiagramType    string            `json:"diagram_type"`
+			OutputFormat   string            `json:"output_format"`
+			DiagramOptions map[string]string `json:"diagram_options"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return request, fmt.Errorf("invalid JSON request: %w", err)
+		}
+		request.Source = payload.DiagramSource
+		if payload.DiagramType != "" {
+			request.DiagramType = payload.DiagramType
+		}
+		if payload.OutputFormat != "" {
+			request.OutputFormat = payload.OutputFormat
+		}
+		for name, value := range payload.DiagramOptions {
+			request.Options[name] = value
+		}
+	default:
+		return request, fmt.Errorf("method %s not allowed", r.Method)
+	}
+	if request.DiagramType == "" || request.OutputFormat == "" {
+		return request, fmt.Errorf("the diagram type and the output format are required")
+	}
+	request.DiagramType, request.OutputFormat = strings.ToLower(request.DiagramType), strings.ToLower(request.OutputFormat)
+	return request, nil
+}
+
+// DecodePayload decodes a diagram encoded in deflate + base64 format, with or without padding
+func DecodePayload(payload string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(payload, "="))
+	if err != nil {
+		return "", fmt.Errorf("fail to decode the payload: %w", err)
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("fail to decompress the payload: %w", err)
+	}
+	defer reader.Close()
+	source, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("fail to decompress the payload: %w", err)
+	}
+	return string(source), nil
+}
+
+// placeholderColor is the color of the placeholder images (PNG and JPEG)
+var placeholderColor = color.RGBA{R: 0x34, G: 0x65, B: 0xa4, A: 0xff}
+
+// Placeholder returns a valid image in the output format and its content type, the image only depends on the diagram type and the format
+func Placeholder(diagramType string, outputFormat string) ([]byte, string, error) {
+	switch outputFormat {
+	case "svg":
+		return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="100" height="40" viewBox="0 0 100 40">`+
+			`<rect width="100" height="40" fill="#3465a4"/><text x="50" y="25" fill="#ffffff" text-anchor="middle">%s</text></svg>`, escapeXML(diagramType))), "image/svg+xml", nil
+	case "png", "jpeg":
+		img := image.NewRGBA(image.Rect(0, 0, 100, 40))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = placeholderColor.R, placeholderColor.G, placeholderColor.B, placeholderColor.A
+		}
+		var buffer bytes.Buffer
+		if outputFormat == "png" {
+			_ = png.Encode(&buffer, img)
+			return buffer.Bytes(), "image/png", nil
+		}
+		_ = jpeg.Encode(&buffer, img, nil)
+		return buffer.Bytes(), "image/jpeg", nil
+	case "pdf":
+		return placeholderPDF(diagramType), "application/pdf", nil
+	case "txt", "utxt":
+		return []byte(diagramType + "\n"), "text/plain; charset=utf-8", nil
+	case "base64":
+		image, _, _ := Placeholder(diagramType, "png")
+		return []byte(base64.StdEncoding.EncodeToString(image)), "text/plain; charset=utf-8", nil
+	}
+	return nil, "", fmt.Errorf("unsupported output format: %s", outputFormat)
+}
+
+// placeholderPDF returns a one-page PDF document displaying the diagram type, the cross-reference table is computed
+func placeholderPDF(diagramType string) []byte {
+	text := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(diagramType)
+	content := fmt.Sprintf("BT /F1 12 Tf 10 15 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 100 40] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buffer.Len()
+		fmt.Fprintf(&buffer, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buffer.Len()
+	fmt.Fprintf(&buffer, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buffer, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buffer, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buffer.Bytes()
+}
+
+func escapeXML(value string) string {
+	var buffer bytes.Buffer
+	_ = xml.EscapeText(&buffer, []byte(value))
+	return buffer.String()
+}
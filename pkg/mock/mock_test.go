@@ -0,0 +1,216 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuzutech/kroki-go"
+)
+
+func newTestServer(t *testing.T) (*Server, kroki.Client, string) {
+	server := New()
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	client := kroki.New(kroki.Configuration{URL: ts.URL, Timeout: 5 * time.Second})
+	return server, client, ts.URL
+}
+
+func TestServerPlaceholders(t *testing.T) {
+	_, client, _ := newTestServer(t)
+	// the long diagrams are sent using a POST request
+	long := "digraph G {\n" + strings.Repeat("  node_with_a_long_name -> other_node_with_a_long_name;\n", 200) + "}"
+	cases := []struct {
+		source      string
+		imageFormat kroki.ImageFormat
+		check       func(image string) error
+	}{
+		{source: "digraph G {Hello->World}", imageFormat: kroki.SVG, check: func(image string) error {
+			if !strings.HasPrefix(image, "<svg") || !strings.Contains(image, ">graphviz</text>") {
+				return fmt.Errorf("invalid SVG image: %s", image)
+			}
+			return nil
+		}},
+		{source: long, imageFormat: kroki.PNG, check: func(image string) error {
+			_, err := png.Decode(strings.NewReader(image))
+			return err
+		}},
+		{source: "digraph G {Hello->World}", imageFormat: kroki.JPEG, check: func(image string) error {
+			_, err := jpeg.Decode(strings.NewReader(image))
+			return err
+		}},
+		{source: "digraph G {Hello->World}", imageFormat: kroki.PDF, check: func(image string) error {
+			if !strings.HasPrefix(image, "%PDF-1.4\n") || !strings.HasSuffix(image, "%%EOF\n") || !strings.Contains(image, "(graphviz) Tj") {
+				return fmt.Errorf("invalid PDF document: %s", image)
+			}
+			return nil
+		}},
+	}
+	for _, c := range cases {
+		result, err := client.FromString(c.source, kroki.GraphViz, c.imageFormat)
+		if err != nil {
+			t.Errorf("FromString(%s) error: %+v", c.imageFormat, err)
+			continue
+		}
+		if err = c.check(result); err != nil {
+			t.Errorf("FromString(%s) error: %+v", c.imageFormat, err)
+		}
+		// the placeholders are deterministic
+		again, _ := client.FromString(c.source, kroki.GraphViz, c.imageFormat)
+		if again != result {
+			t.Errorf("FromString(%s) error: the placeholder must be deterministic", c.imageFormat)
+		}
+	}
+}
+
+func TestServerRecordsRequests(t *testing.T) {
+	server, client, url := newTestServer(t)
+	_, err := client.FromString("digraph G {Hello->World}", kroki.GraphViz, kroki.SVG)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	body := `{"diagram_source": "A -> B", "diagram_type": "D2", "output_format": "png", "diagram_options": {"theme": "200"}}`
+	request, _ := http.NewRequest(http.MethodPost, url+"/?layout=elk", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Kroki-Diagram-Options-Sketch", "true")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	response.Body.Close()
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("Requests error\nexpected: 2 requests\nactual:   %+v", requests)
+	}
+	cases := []struct {
+		actual   string
+		expected string
+	}{
+		{actual: requests[0].Method + " " + requests[0].Path, expected: "GET /graphviz/svg/eNpKyUwvSizIUHBXqPZIzcnJ17ULzy_KSakFDABsQAjG"},
+		{actual: requests[0].DiagramType + " " + requests[0].OutputFormat + " " + requests[0].Source, expected: "graphviz svg digraph G {Hello->World}"},
+		{actual: requests[1].Method + " " + requests[1].Path, expected: "POST /"},
+		{actual: requests[1].DiagramType + " " + requests[1].OutputFormat + " " + requests[1].Source, expected: "d2 png A -> B"},
+		{actual: requests[1].Options["theme"] + " " + requests[1].Options["layout"] + " " + requests[1].Options["sketch"], expected: "200 elk true"},
+	}
+	for _, c := range cases {
+		if c.actual != c.expected {
+			t.Errorf("Requests error\nexpected: %s\nactual:   %s", c.expected, c.actual)
+		}
+	}
+	server.Reset()
+	if len(server.Requests()) != 0 {
+		t.Errorf("Reset error, expected no requests")
+	}
+}
+
+func TestServerScriptedResponses(t *testing.T) {
+	server, client, _ := newTestServer(t)
+	server.RespondOnce(Match{DiagramType: "plantuml"}, Response{StatusCode: http.StatusServiceUnavailable, Body: "unavailable"})
+	server.Respond(Match{Contains: "error"}, Response{StatusCode: http.StatusBadRequest, Body: "Syntax Error? (line: 1)"})
+	server.Respond(Match{OutputFormat: "png"}, Response{Delay: 100 * time.Millisecond})
+
+	_, err := client.FromString("Alice -> Bob", kroki.PlantUML, kroki.SVG)
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("RespondOnce error\nexpected: status 503\nactual:   %v", err)
+	}
+	// the response only applies once
+	_, err = client.FromString("Alice -> Bob", kroki.PlantUML, kroki.SVG)
+	if err != nil {
+		t.Errorf("RespondOnce error\nexpected: no error\nactual:   %v", err)
+	}
+	_, err = client.FromString("an error", kroki.PlantUML, kroki.SVG)
+	if err == nil || !strings.Contains(err.Error(), "Syntax Error? (line: 1)") {
+		t.Errorf("Respond error\nexpected: Syntax Error? (line: 1)\nactual:   %v", err)
+	}
+	start := time.Now()
+	result, err := client.FromString("Alice -> Bob", kroki.PlantUML, kroki.PNG)
+	if err != nil || time.Since(start) < 100*time.Millisecond || !strings.HasPrefix(result, "\x89PNG") {
+		t.Errorf("Respond error\nexpected: a PNG image after 100ms\nactual:   %v after %s", err, time.Since(start))
+	}
+}
+
+func TestServerInvalidRequests(t *testing.T) {
+	_, _, url := newTestServer(t)
+	cases := []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{method: http.MethodGet, path: "/graphviz/svg/not-a-payload", expected: "fail to decode the payload"},
+		{method: http.MethodGet, path: "/graphviz/svg", expected: "invalid path /graphviz/svg, expected: /{type}/{format}/{payload}"},
+		{method: http.MethodGet, path: "/graphviz/gif/eNpKyUwvSizIUHBXqPZIzcnJ17ULzy_KSakFDABsQAjG", expected: "unsupported output format: gif"},
+	}
+	for _, c := range cases {
+		request, _ := http.NewRequest(c.method, url+c.path, nil)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), c.expected) {
+			t.Errorf("%s %s error\nexpected: 400 %s\nactual:   %d %s", c.method, c.path, c.expected, response.StatusCode, body)
+		}
+	}
+}
+
+func TestServerControl(t *testing.T) {
+	_, client, url := newTestServer(t)
+	script := `{"match": {"diagram_type": "mermaid"}, "status": 500, "body": "boom", "times": 1}`
+	response, err := http.Post(url+ControlPrefix+"responses", "application/json", strings.NewReader(script))
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST %sresponses error: %v %v", ControlPrefix, err, response)
+	}
+	_, err = client.FromString("graph TD; A-->B", kroki.Mermaid, kroki.SVG)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("scripted response error\nexpected: boom\nactual:   %v", err)
+	}
+	response, err = http.Get(url + ControlPrefix + "requests")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	var requests []Request
+	err = json.NewDecoder(response.Body).Decode(&requests)
+	response.Body.Close()
+	if err != nil || len(requests) != 1 || requests[0].Source != "graph TD; A-->B" {
+		t.Errorf("GET %srequests error\nexpected: 1 request\nactual:   %+v (%v)", ControlPrefix, requests, err)
+	}
+	response, _ = http.Post(url+ControlPrefix+"reset", "", bytes.NewReader(nil))
+	response.Body.Close()
+	response, _ = http.Get(url + ControlPrefix + "requests")
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if strings.TrimSpace(string(body)) != "[]" {
+		t.Errorf("POST %sreset error\nexpected: []\nactual:   %s", ControlPrefix, body)
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	// with and without padding
+	for _, payload := range []string{"eJxLyUwvSizIUHBXqPZIzcnJ17ULzy_KSakFAGxACMY=", "eJxLyUwvSizIUHBXqPZIzcnJ17ULzy_KSakFAGxACMY"} {
+		result, err := DecodePayload(payload)
+		if err != nil || result != "digraph G {Hello->World}" {
+			t.Errorf("DecodePayload(%s) error\nexpected: %s\nactual:   %s (%v)", payload, "digraph G {Hello->World}", result, err)
+		}
+	}
+}
+
+func TestServerScriptUnlimited(t *testing.T) {
+	server, client, _ := newTestServer(t)
+	// like the times field of the control endpoint, 0 applies the response to all the matching requests
+	server.Script(Match{}, Response{StatusCode: http.StatusServiceUnavailable, Body: "unavailable"}, 0)
+	for i := 0; i < 3; i++ {
+		_, err := client.FromString("Alice -> Bob", kroki.PlantUML, kroki.SVG)
+		if err == nil || !strings.Contains(err.Error(), "503") {
+			t.Errorf("Script error\nexpected: status 503\nactual:   %v", err)
+		}
+	}
+}
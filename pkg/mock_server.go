@@ -0,0 +1,26 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-cli/pkg/mock"
+)
+
+// MockServer starts a fake Kroki server which returns placeholder images (see the mock package)
+func MockServer(cmd *cobra.Command, _ []string) {
+	err := LoadCommandConfig(cmd, "")
+	if err != nil {
+		exit(err)
+	}
+	server := mock.New()
+	server.Delay = viper.GetDuration("mock_server.delay")
+	address := viper.GetString("mock_server.listen")
+	fmt.Fprintf(os.Stderr, "Kroki mock server listening on http://%s (requests recorded on %srequests)\n", address, mock.ControlPrefix)
+	httpServer := &http.Server{Addr: address, Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	exit(httpServer.ListenAndServe())
+}
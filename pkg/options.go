@@ -0,0 +1,33 @@
+package pkg
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
+
+// GetOptions returns the diagram options defined in the configuration
+func GetOptions() map[string]string {
+	return viper.GetStringMapString("options")
+}
+
+// WithOptions returns a copy of the client that sends the diagram options to Kroki (as query parameters)
+func WithOptions(client kroki.Client, options map[string]string) (kroki.Client, error) {
+	if len(options) == 0 {
+		return client, nil
+	}
+	u, err := url.Parse(client.Config.URL)
+	if err != nil {
+		return client, fmt.Errorf("fail to create the URL from %s: %w", client.Config.URL, err)
+	}
+	query := u.Query()
+	for name, value := range options {
+		query.Set(name, value)
+	}
+	u.RawQuery = query.Encode()
+	config := client.Config
+	config.URL = u.String()
+	return kroki.New(config), nil
+}
@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuzutech/kroki-go"
+)
+
+// Diagram is a diagram extracted from a file containing multiple diagrams
+type Diagram struct {
+	// Name is used to name the output file, empty when the file contains a single diagram
+	Name string
+	Text string
+}
+
+var (
+	startPattern   = regexp.MustCompile(`^\s*@start(\w+)\s*(\(id=([^)]*)\)|.*)$`)
+	newpagePattern = regexp.MustCompile(`^\s*newpage\b`)
+	// setupPattern matches the statements of the first page repeated on the following pages
+	setupPattern  = regexp.MustCompile(`^\s*(!|skinparam\b|hide\b|show\b|scale\b|autonumber\b|left to right\b|top to bottom\b|participant\b|actor\b|boundary\b|control\b|entity\b|database\b|collections\b|queue\b)`)
+	unsafePattern = regexp.MustCompile(`[^\w.-]+`)
+)
+
+// isPlantUML returns true if the diagram type uses the PlantUML syntax (@startuml ... @enduml)
+func isPlantUML(diagramType kroki.DiagramType) bool {
+	return diagramType == kroki.PlantUML || diagramType == kroki.C4PlantUML
+}
+
+// SplitPlantUML returns a diagram per @startuml ... @enduml block and per page (separated by newpage),
+// diagrams are named using the name of the block (for instance: @startuml sequence) or their index
+// the text is returned as is when it contains a single diagram
+func SplitPlantUML(text string) []Diagram {
+	type block struct {
+		kind  string
+		name  string
+		pages [][]string
+	}
+	var blocks []block
+	var current *block
+	var end string
+	for _, line := range strings.Split(text, "\n") {
+		if current == nil {
+			if match := startPattern.FindStringSubmatch(line); match != nil {
+				name := match[3]
+				if name == "" {
+					name = strings.Trim(strings.TrimSpace(match[2]), `"`)
+				}
+				kind := strings.ToLower(match[1])
+				blocks = append(blocks, block{kind: kind, name: name, pages: [][]string{nil}})
+				current = &blocks[len(blocks)-1]
+				end = "@end" + kind
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(line)), end) {
+			current = nil
+			continue
+		}
+		if newpagePattern.MatchString(line) {
+			current.pages = append(current.pages, nil)
+			continue
+		}
+		current.pages[len(current.pages)-1] = append(current.pages[len(current.pages)-1], line)
+	}
+	if len(blocks) == 0 || (len(blocks) == 1 && len(blocks[0].pages) == 1) {
+		return []Diagram{{Text: text}}
+	}
+	var diagrams []Diagram
+	for _, b := range blocks {
+		var setup []string
+		for i, page := range b.pages {
+			var lines []string
+			if i == 0 {
+				setup = setupStatements(page)
+				lines = page
+			} else {
+				lines = append(append([]string{}, setup...), page...)
+			}
+			name := b.name
+			if len(b.pages) > 1 && name != "" {
+				name = name + "-" + strconv.Itoa(i+1)
+			}
+			if name == "" {
+				name = strconv.Itoa(len(diagrams) + 1)
+			}
+			diagrams = append(diagrams, Diagram{
+				Name: unsafePattern.ReplaceAllString(name, "_"),
+				Text: "@start" + b.kind + "\n" + strings.Join(lines, "\n") + "\n@end" + b.kind + "\n",
+			})
+		}
+	}
+	return diagrams
+}
+
+// setupStatements returns the statements of the page that also apply to the following pages
+// (for instance: !include, skinparam or participant declarations)
+func setupStatements(lines []string) []string {
+	var result []string
+	depth := 0
+	for _, line := range lines {
+		if depth > 0 || setupPattern.MatchString(line) {
+			result = append(result, line)
+			depth += strings.Count(line, "{") - strings.Count(line, "}")
+		}
+	}
+	return result
+}
+
+// SelectPage returns the diagram at the given position (starting at 1), all the diagrams when page is 0
+func SelectPage(diagrams []Diagram, page int) ([]Diagram, error) {
+	if page == 0 {
+		return diagrams, nil
+	}
+	if page < 0 || page > len(diagrams) {
+		return nil, fmt.Errorf("invalid page: %d, the file contains %d diagram(s)", page, len(diagrams))
+	}
+	diagram := diagrams[page-1]
+	// a single diagram is selected, the output file is not suffixed
+	diagram.Name = ""
+	return []Diagram{diagram}, nil
+}
+
+// DiagramOutputFilePath returns the output file of a diagram, suffixed by the name of the diagram if defined
+func DiagramOutputFilePath(outFile string, filePath string, imageFormat kroki.ImageFormat, name string) string {
+	result := ResolveOutputFilePath(outFile, filePath, imageFormat)
+	if name == "" {
+		return result
+	}
+	extension := path.Ext(result)
+	return result[0:len(result)-len(extension)] + "-" + name + extension
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/yuzutech/kroki-go"
+)
+
+func TestSplitPlantUML(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected []Diagram
+	}{
+		{
+			text:     "@startuml\nAlice -> Bob\n@enduml\n",
+			expected: []Diagram{{Text: "@startuml\nAlice -> Bob\n@enduml\n"}},
+		},
+		{
+			text: "@startuml login\nAlice -> Bob\n@enduml\n\n@startuml\nBob -> Alice\n@enduml\n@startmindmap(id=ideas)\n* root\n@endmindmap\n",
+			expected: []Diagram{
+				{Name: "login", Text: "@startuml\nAlice -> Bob\n@enduml\n"},
+				{Name: "2", Text: "@startuml\nBob -> Alice\n@enduml\n"},
+				{Name: "ideas", Text: "@startmindmap\n* root\n@endmindmap\n"},
+			},
+		},
+		{
+			text: "@startuml \"order flow\"\nskinparam monochrome true\nparticipant Alice\nAlice -> Bob\nnewpage\nBob -> Alice\n@enduml\n",
+			expected: []Diagram{
+				{Name: "order_flow-1", Text: "@startuml\nskinparam monochrome true\nparticipant Alice\nAlice -> Bob\n@enduml\n"},
+				{Name: "order_flow-2", Text: "@startuml\nskinparam monochrome true\nparticipant Alice\nBob -> Alice\n@enduml\n"},
+			},
+		},
+		{
+			text: "@startuml\nskinparam sequence {\n  ArrowColor red\n}\nAlice -> Bob\nnewpage Second page\nBob -> Alice\n@enduml",
+			expected: []Diagram{
+				{Name: "1", Text: "@startuml\nskinparam sequence {\n  ArrowColor red\n}\nAlice -> Bob\n@enduml\n"},
+				{Name: "2", Text: "@startuml\nskinparam sequence {\n  ArrowColor red\n}\nBob -> Alice\n@enduml\n"},
+			},
+		},
+	}
+	for _, c := range cases {
+		result := SplitPlantUML(c.text)
+		if len(result) != len(c.expected) {
+			t.Errorf("SplitPlantUML(%q) error\nexpected: %d diagrams\nactual:   %d diagrams", c.text, len(c.expected), len(result))
+			continue
+		}
+		for i, diagram := range result {
+			if diagram != c.expected[i] {
+				t.Errorf("SplitPlantUML(%q) error\nexpected: %+v\nactual:   %+v", c.text, c.expected[i], diagram)
+			}
+		}
+	}
+}
+
+func TestSelectPage(t *testing.T) {
+	diagrams := []Diagram{{Name: "login", Text: "a"}, {Name: "2", Text: "b"}}
+	result, err := SelectPage(diagrams, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(result) != 1 || result[0] != (Diagram{Text: "b"}) {
+		t.Errorf("SelectPage error\nexpected: %+v\nactual:   %+v", []Diagram{{Text: "b"}}, result)
+	}
+	_, err = SelectPage(diagrams, 3)
+	expected := "invalid page: 3, the file contains 2 diagram(s)"
+	if err == nil || err.Error() != expected {
+		t.Errorf("SelectPage error\nexpected: %s\nactual:   %v", expected, err)
+	}
+}
+
+func TestDiagramOutputFilePath(t *testing.T) {
+	cases := []struct {
+		outFile  string
+		name     string
+		expected string
+	}{
+		{outFile: "", name: "", expected: "docs/flows.svg"},
+		{outFile: "", name: "login", expected: "docs/flows-login.svg"},
+		{outFile: "out/diagram.svg", name: "2", expected: "out/diagram-2.svg"},
+	}
+	for _, c := range cases {
+		result := DiagramOutputFilePath(c.outFile, "docs/flows.puml", kroki.SVG, c.name)
+		if result != c.expected {
+			t.Errorf("DiagramOutputFilePath error\nexpected: %s\nactual:   %s", c.expected, result)
+		}
+	}
+}
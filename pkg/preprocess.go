@@ -0,0 +1,279 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuzutech/kroki-go"
+)
+
+// Preprocessor bundles the local files referenced by a diagram source into a self-contained source,
+// it returns the source and the files that were read (used to write the depfile)
+type Preprocessor func(text string, filePath string, searchPaths []string) (string, []string, error)
+
+// preprocessors contains the preprocessor of each diagram type
+var preprocessors = map[kroki.DiagramType]Preprocessor{
+	kroki.PlantUML:    ResolvePlantUMLIncludes,
+	kroki.C4PlantUML:  ResolvePlantUMLIncludes,
+	kroki.D2:          ResolveD2Imports,
+	kroki.Structurizr: ResolveStructurizrIncludes,
+	kroki.GraphViz:    ResolveGraphvizIncludes,
+}
+
+// RegisterPreprocessor registers the preprocessor of a diagram type, replacing the existing one
+func RegisterPreprocessor(diagramType kroki.DiagramType, preprocessor Preprocessor) {
+	preprocessors[diagramType] = preprocessor
+}
+
+// Preprocess applies the preprocessor registered for the diagram type, the text is returned as is when there is none
+func Preprocess(diagramType kroki.DiagramType, text string, filePath string, searchPaths []string) (string, []string, error) {
+	preprocessor, ok := preprocessors[diagramType]
+	if !ok {
+		return text, nil, nil
+	}
+	return preprocessor(text, filePath, searchPaths)
+}
+
+// sourceResolver reads the files referenced by a diagram source, detects cycles and records the files that were read
+type sourceResolver struct {
+	searchPaths []string
+	files       []string
+	stack       []string
+}
+
+func newSourceResolver(filePath string, searchPaths []string) (*sourceResolver, error) {
+	resolver := &sourceResolver{searchPaths: searchPaths}
+	if filePath != "" && filePath != "-" {
+		absolute, err := filepath.Abs(filePath)
+		if err != nil {
+			return nil, err
+		}
+		resolver.stack = []string{absolute}
+	}
+	return resolver, nil
+}
+
+func baseDir(filePath string) string {
+	if filePath == "" || filePath == "-" {
+		return "."
+	}
+	return filepath.Dir(filePath)
+}
+
+// find returns the absolute path of the referenced file, relative to the directory of the referencing file or to the search paths
+func (r *sourceResolver) find(target string, dir string) (string, error) {
+	candidates := []string{target}
+	if !filepath.IsAbs(target) {
+		candidates = []string{filepath.Join(dir, target)}
+		for _, searchPath := range r.searchPaths {
+			candidates = append(candidates, filepath.Join(searchPath, target))
+		}
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", fmt.Errorf("unable to resolve %s, file not found in: %s", target, strings.Join(append([]string{dir}, r.searchPaths...), ", "))
+}
+
+func (r *sourceResolver) inStack(file string) bool {
+	return containsString(r.stack, file)
+}
+
+// enter returns the content of the file and pushes the file on the stack (until leave is called), an error is returned on cycles
+func (r *sourceResolver) enter(file string) (string, error) {
+	if r.inStack(file) {
+		return "", fmt.Errorf("include cycle: %s", strings.Join(append(r.stack, file), " -> "))
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("fail to read file '%s': %w", file, err)
+	}
+	if !containsString(r.files, file) {
+		r.files = append(r.files, file)
+	}
+	r.stack = append(r.stack, file)
+	return string(content), nil
+}
+
+func (r *sourceResolver) leave() {
+	r.stack = r.stack[:len(r.stack)-1]
+}
+
+// replaceLines replaces the lines matching the pattern using the replace function
+func replaceLines(text string, pattern *regexp.Regexp, replace func(match []string) (string, error)) (string, error) {
+	lines := strings.Split(text, "\n")
+	for n, line := range lines {
+		match := pattern.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil {
+			continue
+		}
+		replacement, err := replace(match)
+		if err != nil {
+			return text, err
+		}
+		lines[n] = replacement
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+var (
+	d2SpreadPattern = regexp.MustCompile(`^(\s*)\.\.\.@("[^"]+"|[^\s;{}]+)\s*$`)
+	d2ImportPattern = regexp.MustCompile(`^(\s*[^\s#][^:#]*:\s*)@("[^"]+"|[^\s;{}]+)\s*$`)
+)
+
+// ResolveD2Imports inlines the D2 imports (x: @file) and spreads (...@file), the .d2 extension is optional
+func ResolveD2Imports(text string, filePath string, searchPaths []string) (string, []string, error) {
+	resolver, err := newSourceResolver(filePath, searchPaths)
+	if err != nil {
+		return text, nil, err
+	}
+	result, err := resolver.resolveD2(text, baseDir(filePath))
+	return result, resolver.files, err
+}
+
+func (r *sourceResolver) resolveD2(text string, dir string) (string, error) {
+	text, err := replaceLines(text, d2SpreadPattern, func(match []string) (string, error) {
+		return r.importD2(match[2], dir)
+	})
+	if err != nil {
+		return text, err
+	}
+	return replaceLines(text, d2ImportPattern, func(match []string) (string, error) {
+		content, err := r.importD2(match[2], dir)
+		if err != nil {
+			return "", err
+		}
+		indent := match[1][:len(match[1])-len(strings.TrimLeft(match[1], " \t"))]
+		return match[1] + "{\n" + content + "\n" + indent + "}", nil
+	})
+}
+
+func (r *sourceResolver) importD2(target string, dir string) (string, error) {
+	target = strings.Trim(target, `"`)
+	if !strings.HasSuffix(target, ".d2") {
+		target += ".d2"
+	}
+	file, err := r.find(target, dir)
+	if err != nil {
+		return "", err
+	}
+	content, err := r.enter(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.leave()
+	content, err = r.resolveD2(content, filepath.Dir(file))
+	return strings.TrimRight(content, "\n"), err
+}
+
+var (
+	structurizrIncludePattern = regexp.MustCompile(`^\s*!include\s+("[^"]+"|\S+)\s*$`)
+	structurizrDocsPattern    = regexp.MustCompile(`^\s*!(docs|adrs)\s+("[^"]+"|\S+)`)
+)
+
+// ResolveStructurizrIncludes inlines the Structurizr DSL !include directives (a file or all the files of a directory)
+// the !docs and !adrs directives are removed since the documentation is not used to render the views
+func ResolveStructurizrIncludes(text string, filePath string, searchPaths []string) (string, []string, error) {
+	resolver, err := newSourceResolver(filePath, searchPaths)
+	if err != nil {
+		return text, nil, err
+	}
+	result, err := resolver.resolveStructurizr(text, baseDir(filePath))
+	return result, resolver.files, err
+}
+
+func (r *sourceResolver) resolveStructurizr(text string, dir string) (string, error) {
+	text, err := replaceLines(text, structurizrDocsPattern, func(match []string) (string, error) {
+		if _, err := r.find(strings.Trim(match[2], `"`), dir); err != nil {
+			return "", err
+		}
+		return "", nil
+	})
+	if err != nil {
+		return text, err
+	}
+	return replaceLines(text, structurizrIncludePattern, func(match []string) (string, error) {
+		target := strings.Trim(match[1], `"`)
+		if strings.Contains(target, "://") {
+			return match[0], nil
+		}
+		file, err := r.find(target, dir)
+		if err != nil {
+			return "", err
+		}
+		files := []string{file}
+		if info, err := os.Stat(file); err == nil && info.IsDir() {
+			files, err = listFiles(file)
+			if err != nil {
+				return "", err
+			}
+		}
+		var contents []string
+		for _, f := range files {
+			content, err := r.enter(f)
+			if err != nil {
+				return "", err
+			}
+			content, err = r.resolveStructurizr(content, filepath.Dir(f))
+			r.leave()
+			if err != nil {
+				return "", err
+			}
+			contents = append(contents, strings.TrimRight(content, "\n"))
+		}
+		return strings.Join(contents, "\n"), nil
+	})
+}
+
+// listFiles returns the files of the directory and its subdirectories sorted by name
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fail to read directory %s: %w", dir, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+var graphvizIncludePattern = regexp.MustCompile(`^\s*#include\s+["<]([^">]+)[">]\s*$`)
+
+// ResolveGraphvizIncludes inlines the C preprocessor #include directives used to share Graphviz definitions
+func ResolveGraphvizIncludes(text string, filePath string, searchPaths []string) (string, []string, error) {
+	resolver, err := newSourceResolver(filePath, searchPaths)
+	if err != nil {
+		return text, nil, err
+	}
+	result, err := resolver.resolveGraphviz(text, baseDir(filePath))
+	return result, resolver.files, err
+}
+
+func (r *sourceResolver) resolveGraphviz(text string, dir string) (string, error) {
+	return replaceLines(text, graphvizIncludePattern, func(match []string) (string, error) {
+		file, err := r.find(match[1], dir)
+		if err != nil {
+			return "", err
+		}
+		content, err := r.enter(file)
+		if err != nil {
+			return "", err
+		}
+		defer r.leave()
+		content, err = r.resolveGraphviz(content, filepath.Dir(file))
+		return strings.TrimRight(content, "\n"), err
+	})
+}
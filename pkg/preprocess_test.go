@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuzutech/kroki-go"
+)
+
+func TestPreprocess(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "shared", "styles.d2"), "style.fill: honeydew\n")
+	writeFile(t, filepath.Join(dir, "shared", "server.d2"), "shape: rectangle\n...@styles\n")
+	writeFile(t, filepath.Join(dir, "model", "people.dsl"), "user = person \"User\"\n")
+	writeFile(t, filepath.Join(dir, "model", "systems", "a.dsl"), "a = softwareSystem \"A\"\n")
+	writeFile(t, filepath.Join(dir, "model", "systems", "b.dsl"), "b = softwareSystem \"B\"\n")
+	writeFile(t, filepath.Join(dir, "docs", "index.md"), "# Documentation\n")
+	writeFile(t, filepath.Join(dir, "common.gv"), "node [shape=box]\n")
+	cases := []struct {
+		diagramType kroki.DiagramType
+		text        string
+		expected    string
+		files       []string
+	}{
+		{
+			diagramType: kroki.D2,
+			text:        "...@shared/styles\nserver: @shared/server.d2\nserver -> db",
+			expected:    "style.fill: honeydew\nserver: {\nshape: rectangle\nstyle.fill: honeydew\n}\nserver -> db",
+			files:       []string{filepath.Join(dir, "shared", "styles.d2"), filepath.Join(dir, "shared", "server.d2")},
+		},
+		{
+			diagramType: kroki.Structurizr,
+			text:        "workspace {\n  !docs docs\n  model {\n    !include model/people.dsl\n    !include model/systems\n    !include https://example.com/model.dsl\n  }\n}",
+			expected:    "workspace {\n\n  model {\nuser = person \"User\"\na = softwareSystem \"A\"\nb = softwareSystem \"B\"\n    !include https://example.com/model.dsl\n  }\n}",
+			files:       []string{filepath.Join(dir, "model", "people.dsl"), filepath.Join(dir, "model", "systems", "a.dsl"), filepath.Join(dir, "model", "systems", "b.dsl")},
+		},
+		{
+			diagramType: kroki.GraphViz,
+			text:        "digraph G {\n#include \"common.gv\"\n  a -> b\n}",
+			expected:    "digraph G {\nnode [shape=box]\n  a -> b\n}",
+			files:       []string{filepath.Join(dir, "common.gv")},
+		},
+		{
+			diagramType: kroki.Mermaid,
+			text:        "graph TD\n  A --> B",
+			expected:    "graph TD\n  A --> B",
+		},
+	}
+	for _, c := range cases {
+		result, files, err := Preprocess(c.diagramType, c.text, filepath.Join(dir, "diagram"), nil)
+		if err != nil {
+			t.Errorf("Preprocess(%s) error\n%+v", c.diagramType, err)
+			continue
+		}
+		if result != c.expected {
+			t.Errorf("Preprocess(%s) error\nexpected: %q\nactual:   %q", c.diagramType, c.expected, result)
+		}
+		if strings.Join(files, "\n") != strings.Join(c.files, "\n") {
+			t.Errorf("Preprocess(%s) error\nexpected: %v\nactual:   %v", c.diagramType, c.files, files)
+		}
+	}
+}
+
+func TestPreprocessErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.d2"), "...@b\n")
+	writeFile(t, filepath.Join(dir, "b.d2"), "...@a\n")
+	cases := []struct {
+		diagramType kroki.DiagramType
+		text        string
+		expected    string
+	}{
+		{
+			diagramType: kroki.D2,
+			text:        "x: @missing",
+			expected:    "unable to resolve missing.d2, file not found in: " + dir,
+		},
+		{
+			diagramType: kroki.D2,
+			text:        "...@a",
+			expected:    "include cycle: " + strings.Join([]string{filepath.Join(dir, "diagram.d2"), filepath.Join(dir, "a.d2"), filepath.Join(dir, "b.d2"), filepath.Join(dir, "a.d2")}, " -> "),
+		},
+		{
+			diagramType: kroki.Structurizr,
+			text:        "workspace {\n  !adrs decisions\n}",
+			expected:    "unable to resolve decisions, file not found in: " + dir,
+		},
+	}
+	for _, c := range cases {
+		_, _, err := Preprocess(c.diagramType, c.text, filepath.Join(dir, "diagram.d2"), nil)
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("Preprocess(%s) error\nexpected: %s\nactual:   %v", c.diagramType, c.expected, err)
+		}
+	}
+}
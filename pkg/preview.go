@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// Graphics protocols used to display the preview in the terminal
+const (
+	// AutoProtocol detects the protocol supported by the terminal from the environment
+	AutoProtocol = "auto"
+	// KittyProtocol is the Kitty graphics protocol, also supported by WezTerm and Ghostty
+	KittyProtocol = "kitty"
+	// ITerm2Protocol is the iTerm2 inline images protocol (OSC 1337), also supported by WezTerm
+	ITerm2Protocol = "iterm2"
+	// SixelProtocol is supported by xterm (-ti vt340), mlterm, foot and Windows Terminal
+	SixelProtocol = "sixel"
+	// BlocksProtocol approximates the image using Unicode half blocks and 24-bit colors, supported by most terminals
+	BlocksProtocol = "blocks"
+)
+
+// kittyChunkSize is the maximum size of the base64 payload of a Kitty graphics escape sequence
+const kittyChunkSize = 4096
+
+// sixelCellWidth is the approximate width (in pixels) of a terminal cell, used to fit the Sixel images in the terminal
+const sixelCellWidth = 10
+
+// DetectProtocol returns the graphics protocol supported by the terminal, getenv returns the value of an environment variable
+// the variables set by the terminal (for instance LC_TERMINAL) are usually forwarded by SSH
+func DetectProtocol(getenv func(string) string) string {
+	term := strings.ToLower(getenv("TERM"))
+	termProgram := strings.ToLower(getenv("TERM_PROGRAM"))
+	switch {
+	case getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" || termProgram == "ghostty":
+		return KittyProtocol
+	case termProgram == "iterm.app" || termProgram == "wezterm" || getenv("LC_TERMINAL") == "iTerm2":
+		return ITerm2Protocol
+	case strings.Contains(term, "sixel") || term == "mlterm" || strings.HasPrefix(term, "foot") || getenv("WT_SESSION") != "":
+		return SixelProtocol
+	}
+	return BlocksProtocol
+}
+
+// PreviewProtocol returns the protocol defined by preview_protocol (auto by default)
+func PreviewProtocol() (string, error) {
+	protocol := strings.ToLower(viper.GetString("preview_protocol"))
+	switch protocol {
+	case "", AutoProtocol:
+		return DetectProtocol(os.Getenv), nil
+	case KittyProtocol, ITerm2Protocol, SixelProtocol, BlocksProtocol:
+		return protocol, nil
+	}
+	return "", fmt.Errorf("invalid preview protocol: %s, expected one of: %s, %s, %s, %s, %s", protocol, AutoProtocol, KittyProtocol, ITerm2Protocol, SixelProtocol, BlocksProtocol)
+}
+
+// terminalColumns returns the width of the terminal, COLUMNS when the standard output is not a terminal
+// (the variable is usually not exported by the shells) and 80 by default
+func terminalColumns() int {
+	if columns, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && columns > 0 {
+		return columns
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 80
+}
+
+// RenderPreview writes the PNG image to the terminal using the graphics protocol,
+// columns is the maximum width (in cells) of the image rendered using half blocks or Sixel graphics
+func RenderPreview(w io.Writer, data []byte, protocol string, columns int) error {
+	switch protocol {
+	case KittyProtocol:
+		return writeKitty(w, data)
+	case ITerm2Protocol:
+		return writeITerm2(w, data)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("fail to decode the PNG image: %w", err)
+	}
+	// the transparent areas are displayed on a white background, like in a browser
+	img = flatten(img)
+	if protocol == SixelProtocol {
+		if columns > 0 && img.Bounds().Dx() > columns*sixelCellWidth {
+			img = scaleImage(img, columns*sixelCellWidth)
+		}
+		return writeSixel(w, img)
+	}
+	return writeBlocks(w, img, columns)
+}
+
+func writeKitty(w io.Writer, data []byte) error {
+	payload := base64.StdEncoding.EncodeToString(data)
+	var buffer bytes.Buffer
+	for offset := 0; offset < len(payload); offset += kittyChunkSize {
+		end := offset + kittyChunkSize
+		more := 1
+		if end >= len(payload) {
+			end = len(payload)
+			more = 0
+		}
+		if offset == 0 {
+			// f=100: PNG, a=T: transmit and display
+			fmt.Fprintf(&buffer, "\x1b_Gf=100,a=T,m=%d;%s\x1b\\", more, payload[offset:end])
+		} else {
+			fmt.Fprintf(&buffer, "\x1b_Gm=%d;%s\x1b\\", more, payload[offset:end])
+		}
+	}
+	buffer.WriteString("\n")
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+func writeITerm2(w io.Writer, data []byte) error {
+	_, err := fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a\n", len(data), base64.StdEncoding.EncodeToString(data))
+	return err
+}
+
+func flatten(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(result, result.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(result, result.Bounds(), img, bounds.Min, draw.Over)
+	return result
+}
+
+// scaleImage scales down the image (nearest neighbor) to the given width, the aspect ratio is preserved
+func scaleImage(img image.Image, width int) *image.RGBA {
+	bounds := img.Bounds()
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			result.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height))
+		}
+	}
+	return result
+}
+
+// writeSixel encodes the image using the 216 web-safe colors, each band of 6 rows is written color by color
+func writeSixel(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(bounds, palette.WebSafe)
+	draw.Draw(paletted, bounds, img, bounds.Min, draw.Src)
+	width, height := bounds.Dx(), bounds.Dy()
+	var buffer bytes.Buffer
+	// P2=1: the pixels not drawn keep the background color, raster attributes: aspect ratio 1:1, width and height
+	fmt.Fprintf(&buffer, "\x1bP0;1;0q\"1;1;%d;%d", width, height)
+	used := make([]bool, len(palette.WebSafe))
+	for _, index := range paletted.Pix {
+		used[index] = true
+	}
+	for index, c := range palette.WebSafe {
+		if used[index] {
+			r, g, b, _ := c.RGBA()
+			fmt.Fprintf(&buffer, "#%d;2;%d;%d;%d", index, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+		}
+	}
+	row := make([]byte, width)
+	for top := 0; top < height; top += 6 {
+		// the colors used in the band, in palette order
+		var colors []uint8
+		seen := make([]bool, len(palette.WebSafe))
+		for y := top; y < top+6 && y < height; y++ {
+			for x := 0; x < width; x++ {
+				seen[paletted.ColorIndexAt(x, y)] = true
+			}
+		}
+		for index := range seen {
+			if seen[index] {
+				colors = append(colors, uint8(index))
+			}
+		}
+		for i, index := range colors {
+			for x := 0; x < width; x++ {
+				bits := byte(0)
+				for dy := 0; dy < 6 && top+dy < height; dy++ {
+					if paletted.ColorIndexAt(x, top+dy) == index {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = '?' + bits
+			}
+			fmt.Fprintf(&buffer, "#%d", index)
+			writeSixelRow(&buffer, row)
+			if i < len(colors)-1 {
+				// carriage return, the next color is drawn over the same band
+				buffer.WriteByte('$')
+			}
+		}
+		buffer.WriteByte('-')
+	}
+	buffer.WriteString("\x1b\\\n")
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// writeSixelRow writes the sixels using the run-length encoding (!count sixel)
+func writeSixelRow(buffer *bytes.Buffer, row []byte) {
+	for x := 0; x < len(row); {
+		count := 1
+		for x+count < len(row) && row[x+count] == row[x] {
+			count++
+		}
+		if count > 3 {
+			fmt.Fprintf(buffer, "!%d%c", count, row[x])
+		} else {
+			buffer.Write(bytes.Repeat([]byte{row[x]}, count))
+		}
+		x += count
+	}
+}
+
+// writeBlocks approximates the image using the upper half block: the foreground is the upper pixel and the background the lower pixel,
+// the image is scaled down (nearest neighbor) to fit in the given number of columns
+func writeBlocks(w io.Writer, img image.Image, columns int) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil
+	}
+	targetWidth := width
+	if columns > 0 && targetWidth > columns {
+		targetWidth = columns
+	}
+	targetHeight := height * targetWidth / width
+	if targetHeight < 2 {
+		targetHeight = 2
+	}
+	pixel := func(x, y int) color.RGBA {
+		r, g, b, _ := img.At(bounds.Min.X+x*width/targetWidth, bounds.Min.Y+y*height/targetHeight).RGBA()
+		return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0xff}
+	}
+	var buffer bytes.Buffer
+	for y := 0; y < targetHeight; y += 2 {
+		for x := 0; x < targetWidth; x++ {
+			upper := pixel(x, y)
+			lower := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+			if y+1 < targetHeight {
+				lower = pixel(x, y+1)
+			}
+			fmt.Fprintf(&buffer, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", upper.R, upper.G, upper.B, lower.R, lower.G, lower.B)
+		}
+		buffer.WriteString("\x1b[0m\n")
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
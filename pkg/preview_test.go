@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestDetectProtocol(t *testing.T) {
+	cases := []struct {
+		env      map[string]string
+		expected string
+	}{
+		{env: map[string]string{"TERM": "xterm-kitty"}, expected: KittyProtocol},
+		{env: map[string]string{"KITTY_WINDOW_ID": "1", "TERM": "xterm-256color"}, expected: KittyProtocol},
+		{env: map[string]string{"TERM_PROGRAM": "iTerm.app"}, expected: ITerm2Protocol},
+		{env: map[string]string{"TERM": "xterm-256color", "LC_TERMINAL": "iTerm2"}, expected: ITerm2Protocol},
+		{env: map[string]string{"TERM": "foot"}, expected: SixelProtocol},
+		{env: map[string]string{"TERM": "xterm-256color"}, expected: BlocksProtocol},
+		{env: map[string]string{}, expected: BlocksProtocol},
+	}
+	for _, c := range cases {
+		result := DetectProtocol(func(name string) string { return c.env[name] })
+		if result != c.expected {
+			t.Errorf("DetectProtocol(%v) error\nexpected: %s\nactual:   %s", c.env, c.expected, result)
+		}
+	}
+}
+
+// testImage returns a 2x2 PNG image: red and transparent on the first row, blue and black on the second row
+func testImage(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	img.Set(0, 1, color.RGBA{B: 0xff, A: 0xff})
+	img.Set(1, 1, color.RGBA{A: 0xff})
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestRenderPreview(t *testing.T) {
+	cases := []struct {
+		protocol string
+		expected string
+	}{
+		{
+			protocol: BlocksProtocol,
+			expected: "\x1b[38;2;255;0;0m\x1b[48;2;0;0;255m▀\x1b[38;2;255;255;255m\x1b[48;2;0;0;0m▀\x1b[0m\n",
+		},
+		{
+			// colors: black (#0), blue (#5), red (#180) and white (#215)
+			protocol: SixelProtocol,
+			expected: "\x1bP0;1;0q\"1;1;2;2#0;2;0;0;0#5;2;0;0;100#180;2;100;0;0#215;2;100;100;100#0?A$#5A?$#180@?$#215?@-\x1b\\\n",
+		},
+	}
+	for _, c := range cases {
+		var buffer bytes.Buffer
+		err := RenderPreview(&buffer, testImage(t), c.protocol, 80)
+		if err != nil {
+			t.Errorf("unexpected error: %+v", err)
+			continue
+		}
+		if buffer.String() != c.expected {
+			t.Errorf("RenderPreview(%s) error\nexpected: %q\nactual:   %q", c.protocol, c.expected, buffer.String())
+		}
+	}
+}
+
+func TestRenderPreviewEncoded(t *testing.T) {
+	data := []byte("\x89PNG")
+	var buffer bytes.Buffer
+	_ = RenderPreview(&buffer, data, ITerm2Protocol, 80)
+	expected := "\x1b]1337;File=inline=1;size=4;preserveAspectRatio=1:iVBORw==\a\n"
+	if buffer.String() != expected {
+		t.Errorf("RenderPreview(iterm2) error\nexpected: %q\nactual:   %q", expected, buffer.String())
+	}
+	buffer.Reset()
+	_ = RenderPreview(&buffer, data, KittyProtocol, 80)
+	expected = "\x1b_Gf=100,a=T,m=0;iVBORw==\x1b\\\n"
+	if buffer.String() != expected {
+		t.Errorf("RenderPreview(kitty) error\nexpected: %q\nactual:   %q", expected, buffer.String())
+	}
+	// the payload is split in chunks of 4096 bytes
+	buffer.Reset()
+	_ = RenderPreview(&buffer, bytes.Repeat([]byte{0}, 4000), KittyProtocol, 80)
+	result := buffer.String()
+	if !strings.HasPrefix(result, "\x1b_Gf=100,a=T,m=1;AAAA") || strings.Count(result, "\x1b_G") != 2 || !strings.Contains(result, "\x1b_Gm=0;") {
+		t.Errorf("RenderPreview(kitty) error, expected 2 chunks\nactual:   %q", result)
+	}
+}
+
+func TestRenderPreviewBlocksScaled(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	var encoded bytes.Buffer
+	_ = png.Encode(&encoded, img)
+	var buffer bytes.Buffer
+	err := RenderPreview(&buffer, encoded.Bytes(), BlocksProtocol, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	// 4 columns and 2 pixel rows (1 line)
+	if lines := strings.Count(buffer.String(), "\n"); lines != 1 {
+		t.Errorf("RenderPreview(blocks) error\nexpected: 1 line\nactual:   %d lines", lines)
+	}
+	if blocks := strings.Count(buffer.String(), "▀"); blocks != 4 {
+		t.Errorf("RenderPreview(blocks) error\nexpected: 4 blocks\nactual:   %d blocks", blocks)
+	}
+}
+
+func TestRenderPreviewSixelScaled(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	var encoded bytes.Buffer
+	_ = png.Encode(&encoded, img)
+	var buffer bytes.Buffer
+	// 4 columns of 10 pixels
+	err := RenderPreview(&buffer, encoded.Bytes(), SixelProtocol, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := "\x1bP0;1;0q\"1;1;40;20"
+	if !strings.HasPrefix(buffer.String(), expected) {
+		t.Errorf("RenderPreview(sixel) error\nexpected: %q\nactual:   %q", expected, buffer.String())
+	}
+}
@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// ActiveProfile returns the name of the selected profile, or an empty string if no profile is selected
+// the --profile flag and the KROKI_PROFILE environment variable take precedence over the default_profile key
+func ActiveProfile() string {
+	if profile := viper.GetString("profile"); profile != "" {
+		return profile
+	}
+	return viper.GetString("default_profile")
+}
+
+// ApplyProfile merges the settings of the active profile into the configuration
+// the profile settings override the top-level settings of the configuration file but not the environment variables and flags
+func ApplyProfile() error {
+	name := ActiveProfile()
+	if name == "" {
+		return nil
+	}
+	profiles := viper.GetStringMap("profiles")
+	profile, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for profileName := range profiles {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown profile: %s, available profiles: %s", name, names)
+	}
+	settings, ok := profile.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid profile %s: must be a map of settings", name)
+	}
+	if _, ok := settings["endpoint"]; ok {
+		if _, ok := settings["endpoints"]; !ok {
+			// the endpoint of the profile replaces the endpoints defined at the top-level
+			settings["endpoints"] = []string{}
+		}
+	}
+	return viper.MergeConfigMap(settings)
+}
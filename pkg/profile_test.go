@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
+
+// loadTestConfig replaces the configuration with the given YAML content until the end of the test
+func loadTestConfig(t *testing.T, content string) {
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	t.Cleanup(func() {
+		_ = viper.ReadConfig(strings.NewReader(""))
+		viper.Set("profile", nil)
+	})
+}
+
+const profilesConfig = `
+endpoints:
+  - https://kroki-eu.example.com
+  - https://kroki-us.example.com
+timeout: 10s
+default_profile: staging
+profiles:
+  demo:
+    endpoint: https://demo.kroki.io
+  staging:
+    endpoints: [https://kroki.staging.example.com]
+    timeout: 30s
+    auth:
+      bearer_token: staging-token
+    options:
+      theme: dark
+`
+
+func TestApplyProfile(t *testing.T) {
+	cases := []struct {
+		profile   string
+		endpoints []string
+		timeout   time.Duration
+		token     string
+		options   map[string]string
+	}{
+		{
+			profile:   "",
+			endpoints: []string{"https://kroki.staging.example.com"},
+			timeout:   30 * time.Second,
+			token:     "staging-token",
+			options:   map[string]string{"theme": "dark"},
+		},
+		{
+			profile:   "demo",
+			endpoints: []string{"https://demo.kroki.io"},
+			timeout:   10 * time.Second,
+		},
+	}
+	for _, c := range cases {
+		loadTestConfig(t, profilesConfig)
+		viper.Set("profile", c.profile)
+		err := ApplyProfile()
+		if err != nil {
+			t.Fatalf("ApplyProfile(%s) error\n%+v", c.profile, err)
+		}
+		endpoints := Endpoints()
+		if strings.Join(endpoints, ",") != strings.Join(c.endpoints, ",") {
+			t.Errorf("ApplyProfile(%s) error\nexpected: %v\nactual:   %v", c.profile, c.endpoints, endpoints)
+		}
+		if viper.GetDuration("timeout") != c.timeout {
+			t.Errorf("ApplyProfile(%s) error\nexpected: %s\nactual:   %s", c.profile, c.timeout, viper.GetDuration("timeout"))
+		}
+		if viper.GetString("auth.bearer_token") != c.token {
+			t.Errorf("ApplyProfile(%s) error\nexpected: %s\nactual:   %s", c.profile, c.token, viper.GetString("auth.bearer_token"))
+		}
+		options := GetOptions()
+		if len(options) != len(c.options) || options["theme"] != c.options["theme"] {
+			t.Errorf("ApplyProfile(%s) error\nexpected: %v\nactual:   %v", c.profile, c.options, options)
+		}
+	}
+}
+
+func TestApplyProfileEnvironmentVariable(t *testing.T) {
+	loadTestConfig(t, profilesConfig)
+	t.Setenv("KROKI_PROFILE", "demo")
+	t.Setenv("KROKI_TIMEOUT", "1m")
+	err := ApplyProfile()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if Endpoints()[0] != "https://demo.kroki.io" {
+		t.Errorf("ApplyProfile error\nexpected: %s\nactual:   %s", "https://demo.kroki.io", Endpoints()[0])
+	}
+	// environment variables take precedence over the profile
+	if viper.GetDuration("timeout") != time.Minute {
+		t.Errorf("ApplyProfile error\nexpected: %s\nactual:   %s", time.Minute, viper.GetDuration("timeout"))
+	}
+}
+
+func TestApplyProfileUnknown(t *testing.T) {
+	loadTestConfig(t, profilesConfig)
+	viper.Set("profile", "production")
+	err := ApplyProfile()
+	expected := "unknown profile: production, available profiles: [demo staging]"
+	if err == nil || err.Error() != expected {
+		t.Errorf("ApplyProfile error\nexpected: %s\nactual:   %v", expected, err)
+	}
+}
+
+func TestWithOptions(t *testing.T) {
+	client := kroki.New(kroki.Configuration{URL: "https://kroki.example.com/base", Timeout: time.Second})
+	result, err := WithOptions(client, map[string]string{"theme": "dark", "no-background": "true"})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := "https://kroki.example.com/base?no-background=true&theme=dark"
+	if result.Config.URL != expected {
+		t.Errorf("WithOptions error\nexpected: %s\nactual:   %s", expected, result.Config.URL)
+	}
+	result, _ = WithOptions(client, nil)
+	if result.Config.URL != client.Config.URL {
+		t.Errorf("WithOptions error\nexpected: %s\nactual:   %s", client.Config.URL, result.Config.URL)
+	}
+}
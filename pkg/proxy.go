@@ -0,0 +1,141 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ProxyConfig contains the proxy settings, empty values fall back to the standard environment variables
+type ProxyConfig struct {
+	// Proxy is the URL of the proxy used for all requests, overrides HTTP_PROXY and HTTPS_PROXY
+	Proxy string
+	// NoProxy is a comma-separated list of hosts that must be reached directly, overrides NO_PROXY
+	NoProxy string
+}
+
+// ProxyFunc returns the function used by the HTTP transport to select a proxy for a given request
+// like http.ProxyFromEnvironment, requests to localhost are never proxied
+func (c ProxyConfig) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	noProxy := c.NoProxy
+	if noProxy == "" {
+		noProxy = getEnvAny("NO_PROXY", "no_proxy")
+	}
+	var proxyURL *url.URL
+	if c.Proxy != "" {
+		var err error
+		proxyURL, err = parseProxyURL(c.Proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return excludeUnixSockets(func(req *http.Request) (*url.URL, error) {
+		if isLocalhost(req.URL.Hostname()) || MatchNoProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		if proxyURL != nil {
+			return proxyURL, nil
+		}
+		var value string
+		if req.URL.Scheme == "https" {
+			value = getEnvAny("HTTPS_PROXY", "https_proxy")
+		} else {
+			value = getEnvAny("HTTP_PROXY", "http_proxy")
+		}
+		if value == "" {
+			return nil, nil
+		}
+		return parseProxyURL(value)
+	}), nil
+}
+
+func parseProxyURL(value string) (*url.URL, error) {
+	proxyURL, err := url.Parse(value)
+	if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+		// the scheme is optional, for instance: proxy.example.com:3128
+		proxyURL, err = url.Parse("http://" + value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %s: %w", value, err)
+		}
+	}
+	return proxyURL, nil
+}
+
+func isLocalhost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// excludeUnixSockets makes sure that the endpoints using a Unix domain socket are never reached through a proxy
+func excludeUnixSockets(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if _, ok := lookupUnixSocket(req.URL.Hostname()); ok {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
+
+// MatchNoProxy returns true if the URL matches one of the comma-separated entries of a NO_PROXY value
+// an entry can be "*", a domain name (example.com also matches sub.example.com), an IP address or a CIDR range
+// and can be followed by a port number
+func MatchNoProxy(u *url.URL, noProxy string) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		if u.Scheme == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost = strings.TrimPrefix(strings.TrimPrefix(entryHost, "*"), ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
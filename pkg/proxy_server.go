@@ -0,0 +1,416 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Values of the X-Kroki-Cache response header
+const (
+	CacheHit = "hit"
+	// CacheMiss means that the diagram was converted by the upstream server
+	CacheMiss = "miss"
+	// CacheCoalesced means that the response of an identical in-flight request was used
+	CacheCoalesced = "coalesced"
+)
+
+// ProxyServer is a caching reverse proxy of the Kroki HTTP API,
+// the identical requests are forwarded once (request coalescing) and the successful responses are cached on disk
+type ProxyServer struct {
+	// Upstream is the base URL of the Kroki server, the requests are sent using Client (load balancing, retries...)
+	Upstream string
+	Client   *http.Client
+	// Cache is nil when the cache is disabled
+	Cache *DiskCache
+	// MaxRequestSize is the maximum size (in bytes) of the encoded diagram (GET) or of the request body (POST), unlimited when 0
+	MaxRequestSize int64
+	// Limiter is nil when the requests are not rate limited
+	Limiter *RateLimiter
+
+	mutex    sync.Mutex
+	inflight map[string]*proxyCall
+}
+
+// proxyResponse is a response of the upstream server
+type proxyResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// proxyCall is an in-flight upstream request, the response is available when done is closed
+type proxyCall struct {
+	done     chan struct{}
+	response proxyResponse
+	err      error
+}
+
+// NewProxyServer returns a proxy server which forwards the requests to the upstream server
+func NewProxyServer(upstream string, client *http.Client) *ProxyServer {
+	return &ProxyServer{
+		Upstream: strings.TrimSuffix(upstream, "/"),
+		Client:   client,
+		inflight: map[string]*proxyCall{},
+	}
+}
+
+func (s *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Limiter != nil {
+		if wait := s.Limiter.Reserve(clientAddress(r), time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body []byte
+	if r.Method == http.MethodGet {
+		// GET /{type}/{format}/{payload}
+		if s.MaxRequestSize > 0 && int64(len(r.URL.Path)) > s.MaxRequestSize {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	} else {
+		reader := io.Reader(r.Body)
+		if s.MaxRequestSize > 0 {
+			reader = io.LimitReader(r.Body, s.MaxRequestSize+1)
+		}
+		var err error
+		body, err = io.ReadAll(reader)
+		if err != nil {
+			http.Error(w, "fail to read the request", http.StatusBadRequest)
+			return
+		}
+		if s.MaxRequestSize > 0 && int64(len(body)) > s.MaxRequestSize {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	key := proxyCacheKey(r, body)
+	response, status, err := s.fetch(key, r, body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("fail to reach the upstream server: %v", err), http.StatusBadGateway)
+		return
+	}
+	if response.ContentType != "" {
+		w.Header().Set("Content-Type", response.ContentType)
+	}
+	w.Header().Set("X-Kroki-Cache", status)
+	w.WriteHeader(response.StatusCode)
+	_, _ = w.Write(response.Body)
+}
+
+// fetch returns the cached response or forwards the request, the identical requests received while the request is in flight
+// wait for its response
+func (s *ProxyServer) fetch(key string, r *http.Request, body []byte) (proxyResponse, string, error) {
+	if s.Cache != nil {
+		if response, ok := s.Cache.Get(key); ok {
+			return response, CacheHit, nil
+		}
+	}
+	s.mutex.Lock()
+	if call, ok := s.inflight[key]; ok {
+		s.mutex.Unlock()
+		<-call.done
+		return call.response, CacheCoalesced, call.err
+	}
+	call := &proxyCall{done: make(chan struct{})}
+	s.inflight[key] = call
+	s.mutex.Unlock()
+
+	call.response, call.err = s.forward(r, body)
+	if call.err == nil && call.response.StatusCode == http.StatusOK && s.Cache != nil {
+		if err := s.Cache.Put(key, call.response); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+	}
+	s.mutex.Lock()
+	delete(s.inflight, key)
+	s.mutex.Unlock()
+	close(call.done)
+	return call.response, CacheMiss, call.err
+}
+
+// forward sends the request to the upstream server, the request context is not used
+// since the response can be shared with other clients
+func (s *ProxyServer) forward(r *http.Request, body []byte) (proxyResponse, error) {
+	target := s.Upstream + r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	request, err := http.NewRequest(r.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return proxyResponse{}, err
+	}
+	for _, name := range []string{"Accept", "Content-Type"} {
+		if value := r.Header.Get(name); value != "" {
+			request.Header.Set(name, value)
+		}
+	}
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return proxyResponse{}, err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return proxyResponse{}, err
+	}
+	return proxyResponse{StatusCode: response.StatusCode, ContentType: response.Header.Get("Content-Type"), Body: content}, nil
+}
+
+// proxyCacheKey returns the key of the request: method, path, query, the headers used by Kroki to select the output and the body
+func proxyCacheKey(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n%s\n%s\n", r.Method, r.URL.EscapedPath(), r.URL.RawQuery, r.Header.Get("Accept"), r.Header.Get("Content-Type"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// clientAddress returns the IP address of the client
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// DiskCache stores the responses in a directory, one file per response
+type DiskCache struct {
+	Dir string
+	// MaxSize is the maximum size (in bytes) of the cached responses, the least recently used responses are removed
+	// when the size is exceeded, unlimited when 0
+	MaxSize int64
+
+	mutex sync.Mutex
+	// size is the size of the cached responses, computed when the first response is stored
+	size      int64
+	sizeKnown bool
+}
+
+// path returns the file of the key, the files are spread in subdirectories (for instance: ab/abcdef...)
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.Dir, key[:2], key)
+}
+
+// Get returns the cached response, the file contains the content type on the first line followed by the body
+func (c *DiskCache) Get(key string) (proxyResponse, bool) {
+	file := c.path(key)
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return proxyResponse{}, false
+	}
+	if c.MaxSize > 0 {
+		// the modification time is the last use of the response
+		now := time.Now()
+		_ = os.Chtimes(file, now, now)
+	}
+	i := bytes.IndexByte(content, '\n')
+	if i < 0 {
+		return proxyResponse{}, false
+	}
+	return proxyResponse{StatusCode: http.StatusOK, ContentType: string(content[:i]), Body: content[i+1:]}, true
+}
+
+// Put stores the response, the file is written atomically so that a shared cache directory can be used by multiple processes
+func (c *DiskCache) Put(key string, response proxyResponse) error {
+	file := c.path(key)
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return fmt.Errorf("fail to create the cache directory: %w", err)
+	}
+	temp, err := os.CreateTemp(filepath.Dir(file), key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("fail to write the cache file: %w", err)
+	}
+	content := append([]byte(response.ContentType+"\n"), response.Body...)
+	_, err = temp.Write(content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), file)
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("fail to write the cache file: %w", err)
+	}
+	if c.MaxSize > 0 {
+		c.grow(int64(len(content)))
+	}
+	return nil
+}
+
+// cacheFile is a cached response
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// files returns the cached responses, the temporary files are ignored
+func (c *DiskCache) files() []cacheFile {
+	var files []cacheFile
+	_ = filepath.Walk(c.Dir, func(file string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && !strings.HasSuffix(file, ".tmp") {
+			files = append(files, cacheFile{path: file, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	return files
+}
+
+// grow adds the size of a stored response, when the maximum size is exceeded the least recently used responses are removed
+// until the size is below 90% of the maximum size, so that the directory is not scanned on every response
+func (c *DiskCache) grow(size int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.sizeKnown {
+		c.size += size
+		if c.size <= c.MaxSize {
+			return
+		}
+	}
+	// the directory can be shared by multiple processes, the size is computed again
+	files := c.files()
+	c.size = 0
+	for _, file := range files {
+		c.size += file.size
+	}
+	c.sizeKnown = true
+	if c.size <= c.MaxSize {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, file := range files {
+		if c.size <= c.MaxSize/10*9 {
+			break
+		}
+		if err := os.Remove(file.path); err == nil || os.IsNotExist(err) {
+			c.size -= file.size
+		}
+	}
+}
+
+// RateLimiter limits the number of requests per client using a token bucket
+type RateLimiter struct {
+	// Rate is the number of requests per second
+	Rate float64
+	// Burst is the maximum number of requests sent at once
+	Burst int
+
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// rateLimiterSweepInterval is the minimum delay between two removals of the full buckets
+const rateLimiterSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a rate limiter, the burst is at least 1
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{Rate: rate, Burst: burst, buckets: map[string]*tokenBucket{}}
+}
+
+// Reserve consumes a token of the client, it returns 0 when the request is allowed otherwise the delay until a token is available
+func (l *RateLimiter) Reserve(client string, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if now.Sub(l.lastSweep) >= rateLimiterSweepInterval {
+		l.sweep(now)
+	}
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(float64(l.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*l.Rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / l.Rate * float64(time.Second))
+}
+
+// sweep removes the buckets that are full again, a full bucket is equivalent to a new one,
+// so that the memory used by the limiter does not grow with the number of clients seen
+func (l *RateLimiter) sweep(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.Rate >= float64(l.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// defaultCacheDir returns the cache directory of the proxy in the user cache directory
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "kroki-cache")
+	}
+	return filepath.Join(dir, "kroki")
+}
+
+// Proxy starts the caching reverse proxy of the configured Kroki endpoints
+func Proxy(cmd *cobra.Command, _ []string) {
+	err := LoadCommandConfig(cmd, "")
+	if err != nil {
+		exit(err)
+	}
+	transport, err := NewTransport()
+	if err != nil {
+		exit(err)
+	}
+	server := NewProxyServer(Endpoints()[0], &http.Client{Transport: transport, Timeout: RequestTimeout(viper.GetDuration("timeout"))})
+	if !viper.GetBool("proxy_server.no_cache") {
+		dir := viper.GetString("proxy_server.cache_dir")
+		if dir == "" {
+			dir = defaultCacheDir()
+		}
+		server.Cache = &DiskCache{Dir: dir, MaxSize: viper.GetInt64("proxy_server.max_cache_size")}
+	}
+	server.MaxRequestSize = viper.GetInt64("proxy_server.max_request_size")
+	if rate := viper.GetFloat64("proxy_server.rate_limit"); rate > 0 {
+		server.Limiter = NewRateLimiter(rate, viper.GetInt("proxy_server.burst"))
+	}
+	address := viper.GetString("proxy_server.listen")
+	fmt.Fprintf(os.Stderr, "Forwarding the requests received on %s to %s\n", address, server.Upstream)
+	if server.Cache != nil {
+		fmt.Fprintf(os.Stderr, "Cache directory: %s\n", server.Cache.Dir)
+	}
+	httpServer := &http.Server{Addr: address, Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	exit(httpServer.ListenAndServe())
+}
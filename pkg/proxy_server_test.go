@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestUpstream returns a Kroki server which converts the diagrams after a delay, the number of requests is counted
+func newTestUpstream(t *testing.T, delay time.Duration, requests *int32) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		time.Sleep(delay)
+		if strings.Contains(r.URL.Path, "invalid") {
+			http.Error(w, "Syntax error", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "image/svg+xml")
+		_, _ = io.WriteString(w, "<svg>"+r.Method+" "+r.URL.Path+" "+string(body)+"</svg>")
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func proxyRequest(handler http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestProxyServerCache(t *testing.T) {
+	var requests int32
+	upstream := newTestUpstream(t, 0, &requests)
+	cache := &DiskCache{Dir: t.TempDir()}
+	server := NewProxyServer(upstream.URL+"/", http.DefaultClient)
+	server.Cache = cache
+	cases := []struct {
+		method string
+		target string
+		body   string
+		cache  string
+	}{
+		{method: http.MethodGet, target: "/graphviz/svg/eNpLyUwvSizIUNC1UwAAHlsEHw==", cache: CacheMiss},
+		{method: http.MethodGet, target: "/graphviz/svg/eNpLyUwvSizIUNC1UwAAHlsEHw==", cache: CacheHit},
+		{method: http.MethodPost, target: "/graphviz/svg", body: "digraph { a -> b }", cache: CacheMiss},
+		{method: http.MethodPost, target: "/graphviz/svg", body: "digraph { a -> b }", cache: CacheHit},
+		{method: http.MethodPost, target: "/graphviz/svg", body: "digraph { a -> c }", cache: CacheMiss},
+		// the errors are not cached
+		{method: http.MethodGet, target: "/graphviz/svg/invalid", cache: CacheMiss},
+		{method: http.MethodGet, target: "/graphviz/svg/invalid", cache: CacheMiss},
+	}
+	for _, c := range cases {
+		response := proxyRequest(server, c.method, c.target, c.body)
+		if status := response.Header().Get("X-Kroki-Cache"); status != c.cache {
+			t.Errorf("%s %s error\nexpected: %s\nactual:   %s", c.method, c.target, c.cache, status)
+		}
+	}
+	if requests != 5 {
+		t.Errorf("upstream requests error\nexpected: 5\nactual:   %d", requests)
+	}
+	// the cache is stored on disk and shared between the instances
+	other := NewProxyServer(upstream.URL, http.DefaultClient)
+	other.Cache = cache
+	response := proxyRequest(other, http.MethodPost, "/graphviz/svg", "digraph { a -> b }")
+	expected := "<svg>POST /graphviz/svg digraph { a -> b }</svg>"
+	if response.Header().Get("X-Kroki-Cache") != CacheHit || response.Body.String() != expected || response.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Errorf("cached response error\nexpected: %s\nactual:   %s %s", expected, response.Header(), response.Body.String())
+	}
+}
+
+func TestProxyServerCoalescing(t *testing.T) {
+	var requests int32
+	upstream := newTestUpstream(t, 200*time.Millisecond, &requests)
+	server := NewProxyServer(upstream.URL, http.DefaultClient)
+	var wg sync.WaitGroup
+	statuses := make([]string, 5)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response := proxyRequest(server, http.MethodGet, "/plantuml/png/SoWkIImgAStDuNBAJrBGjLDmpCbCJbMmKiX8pSd9vt98pKi1IW80", "")
+			statuses[i] = response.Header().Get("X-Kroki-Cache")
+		}(i)
+	}
+	wg.Wait()
+	if requests != 1 {
+		t.Errorf("upstream requests error\nexpected: 1\nactual:   %d", requests)
+	}
+	expected := "coalesced, coalesced, coalesced, coalesced, miss"
+	sort.Strings(statuses)
+	if strings.Join(statuses, ", ") != expected {
+		t.Errorf("X-Kroki-Cache error\nexpected: %s\nactual:   %s", expected, strings.Join(statuses, ", "))
+	}
+}
+
+func TestProxyServerLimits(t *testing.T) {
+	var requests int32
+	upstream := newTestUpstream(t, 0, &requests)
+	server := NewProxyServer(upstream.URL, http.DefaultClient)
+	server.MaxRequestSize = 32
+	cases := []struct {
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{method: http.MethodGet, target: "/graphviz/svg/eNpLyUwvSizIUNC1UwAAHlsEHw==", code: http.StatusRequestEntityTooLarge},
+		{method: http.MethodPost, target: "/graphviz/svg", body: strings.Repeat("a", 33), code: http.StatusRequestEntityTooLarge},
+		{method: http.MethodPost, target: "/graphviz/svg", body: strings.Repeat("a", 32), code: http.StatusOK},
+		{method: http.MethodDelete, target: "/graphviz/svg", code: http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		response := proxyRequest(server, c.method, c.target, c.body)
+		if response.Code != c.code {
+			t.Errorf("%s %s error\nexpected: %d\nactual:   %d", c.method, c.target, c.code, response.Code)
+		}
+	}
+	server.Limiter = NewRateLimiter(0.5, 2)
+	var codes []int
+	for i := 0; i < 3; i++ {
+		response := proxyRequest(server, http.MethodPost, "/graphviz/svg", "a")
+		codes = append(codes, response.Code)
+		if response.Code == http.StatusTooManyRequests && response.Header().Get("Retry-After") != "2" {
+			t.Errorf("Retry-After error\nexpected: 2\nactual:   %s", response.Header().Get("Retry-After"))
+		}
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("rate limit error\nexpected: [200 200 429]\nactual:   %v", codes)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(2, 1)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		client   string
+		elapsed  time.Duration
+		expected time.Duration
+	}{
+		{client: "10.0.0.1", expected: 0},
+		{client: "10.0.0.1", expected: 500 * time.Millisecond},
+		// each client has its own bucket
+		{client: "10.0.0.2", expected: 0},
+		{client: "10.0.0.1", elapsed: 250 * time.Millisecond, expected: 250 * time.Millisecond},
+		{client: "10.0.0.1", elapsed: 500 * time.Millisecond, expected: 0},
+	}
+	for _, c := range cases {
+		result := limiter.Reserve(c.client, now.Add(c.elapsed))
+		if result != c.expected {
+			t.Errorf("Reserve(%s, +%s) error\nexpected: %s\nactual:   %s", c.client, c.elapsed, c.expected, result)
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	// a token every 100 seconds
+	limiter := NewRateLimiter(0.01, 2)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.Reserve("10.0.0.1", now)
+	limiter.Reserve("10.0.0.2", now.Add(rateLimiterSweepInterval))
+	limiter.Reserve("10.0.0.3", now.Add(110*time.Second))
+	// the bucket of the first client is full again after 100 seconds, the other buckets are not
+	limiter.Reserve("10.0.0.4", now.Add(2*rateLimiterSweepInterval))
+	if _, ok := limiter.buckets["10.0.0.1"]; ok {
+		t.Errorf("sweep error: the full bucket of 10.0.0.1 must be removed")
+	}
+	if len(limiter.buckets) != 3 {
+		t.Errorf("sweep error\nexpected: 3 buckets\nactual:   %d buckets", len(limiter.buckets))
+	}
+}
+
+func TestDiskCacheMaxSize(t *testing.T) {
+	// each response uses 1 + 99 bytes, the least recently used responses are removed until the size is below 315 bytes
+	cache := &DiskCache{Dir: t.TempDir(), MaxSize: 350}
+	response := proxyResponse{StatusCode: http.StatusOK, Body: []byte(strings.Repeat("a", 99))}
+	keys := []string{"aa01", "bb02", "cc03"}
+	for i, key := range keys {
+		err := cache.Put(key, response)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		modTime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		_ = os.Chtimes(cache.path(key), modTime, modTime)
+	}
+	// the first response is used, the second one is the least recently used
+	if _, ok := cache.Get("aa01"); !ok {
+		t.Fatalf("Get error: the response must be cached")
+	}
+	err := cache.Put("dd04", response)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	for key, expected := range map[string]bool{"aa01": true, "bb02": false, "cc03": true, "dd04": true} {
+		if _, ok := cache.Get(key); ok != expected {
+			t.Errorf("Get(%s) error\nexpected: %v\nactual:   %v", key, expected, ok)
+		}
+	}
+}
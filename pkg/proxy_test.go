@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestMatchNoProxy(t *testing.T) {
+	cases := []struct {
+		url      string
+		noProxy  string
+		expected bool
+	}{
+		{url: "https://kroki.io", noProxy: "", expected: false},
+		{url: "https://kroki.io", noProxy: "*", expected: true},
+		{url: "https://kroki.io", noProxy: "example.com, kroki.io", expected: true},
+		{url: "https://demo.kroki.io", noProxy: "kroki.io", expected: true},
+		{url: "https://demo.kroki.io", noProxy: ".kroki.io", expected: true},
+		{url: "https://notkroki.io", noProxy: "kroki.io", expected: false},
+		{url: "https://kroki.io", noProxy: "kroki.io:8443", expected: false},
+		{url: "https://kroki.io:8443", noProxy: "kroki.io:8443", expected: true},
+		{url: "http://10.1.2.3:8000", noProxy: "10.0.0.0/8", expected: true},
+		{url: "http://192.168.1.1:8000", noProxy: "10.0.0.0/8", expected: false},
+		{url: "http://[::1]:8000", noProxy: "::1", expected: true},
+	}
+	for _, c := range cases {
+		u, _ := url.Parse(c.url)
+		result := MatchNoProxy(u, c.noProxy)
+		if result != c.expected {
+			t.Errorf("MatchNoProxy(%s, %s) error\nexpected: %t\nactual:   %t", c.url, c.noProxy, c.expected, result)
+		}
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://env-proxy.example.com:3128")
+	t.Setenv("NO_PROXY", "internal.example.com")
+	cases := []struct {
+		config   ProxyConfig
+		url      string
+		expected string
+	}{
+		{config: ProxyConfig{}, url: "http://kroki.example.com", expected: "http://env-proxy.example.com:3128"},
+		{config: ProxyConfig{}, url: "http://internal.example.com", expected: ""},
+		{config: ProxyConfig{Proxy: "proxy.example.com:8080"}, url: "http://kroki.example.com", expected: "http://proxy.example.com:8080"},
+		{config: ProxyConfig{Proxy: "http://proxy.example.com:8080"}, url: "http://internal.example.com", expected: ""},
+		{config: ProxyConfig{Proxy: "http://proxy.example.com:8080", NoProxy: "kroki.example.com"}, url: "http://internal.example.com", expected: "http://proxy.example.com:8080"},
+		{config: ProxyConfig{NoProxy: "kroki.example.com"}, url: "http://kroki.example.com", expected: ""},
+		{config: ProxyConfig{Proxy: "http://proxy.example.com:8080"}, url: ResolveEndpoint("unix:///var/run/kroki.sock"), expected: ""},
+	}
+	for _, c := range cases {
+		proxy, err := c.config.ProxyFunc()
+		if err != nil {
+			t.Errorf("ProxyConfig(%+v) error\n%+v", c.config, err)
+			continue
+		}
+		req, _ := http.NewRequest(http.MethodGet, c.url, nil)
+		result, err := proxy(req)
+		if err != nil {
+			t.Errorf("ProxyConfig(%+v) error\n%+v", c.config, err)
+			continue
+		}
+		actual := ""
+		if result != nil {
+			actual = result.String()
+		}
+		if actual != c.expected {
+			t.Errorf("ProxyConfig(%+v) %s error\nexpected: %s\nactual:   %s", c.config, c.url, c.expected, actual)
+		}
+	}
+}
+
+func TestProxyTransport(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy receives the absolute URL of the target
+		_, _ = w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+	viper.Set("proxy", proxy.URL)
+	defer viper.Set("proxy", nil)
+	transport, err := newBaseTransport()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	response, err := (&http.Client{Transport: transport}).Get("http://kroki.example.com/graphviz/svg/payload")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	expected := "proxied http://kroki.example.com/graphviz/svg/payload"
+	if string(body) != expected {
+		t.Errorf("ProxyTransport error\nexpected: %s\nactual:   %s", expected, string(body))
+	}
+}
+
+func TestUnixSocketEndpoint(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "kroki.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix domain sockets are not supported: %+v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<svg>" + r.URL.Path + "</svg>"))
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	endpoint := ResolveEndpoint("unix://" + socketPath)
+	if endpoint == "unix://"+socketPath {
+		t.Fatalf("ResolveEndpoint error\nexpected an HTTP URL\nactual:   %s", endpoint)
+	}
+	transport, err := newBaseTransport()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	response, err := (&http.Client{Transport: transport}).Get(endpoint + "/graphviz/svg/payload")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	expected := "<svg>/graphviz/svg/payload</svg>"
+	if string(body) != expected {
+		t.Errorf("UnixSocketEndpoint error\nexpected: %s\nactual:   %s", expected, string(body))
+	}
+}
@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// maxRetryBackoff caps the exponential backoff between two attempts
+const maxRetryBackoff = 30 * time.Second
+
+// RetryPolicy describes when and how often a failed request is sent again
+type RetryPolicy struct {
+	// Retries is the number of additional attempts after the first one
+	Retries int
+	// Backoff is the base delay, doubled after each attempt
+	Backoff time.Duration
+	// RetryOn contains the HTTP status codes that are considered transient
+	RetryOn []int
+}
+
+// maxBackoff returns the longest total delay between the attempts, without Retry-After headers
+func (p RetryPolicy) maxBackoff() time.Duration {
+	var total time.Duration
+	for attempt := 0; attempt < p.Retries; attempt++ {
+		delay := p.Backoff << uint(attempt)
+		if delay <= 0 || delay > maxRetryBackoff {
+			delay = maxRetryBackoff
+		}
+		total += delay
+	}
+	return total
+}
+
+// Budget returns the overall timeout of a request when each attempt has the given timeout:
+// the timeout of all the attempts plus the delays between them
+func (p RetryPolicy) Budget(timeout time.Duration) time.Duration {
+	if p.Retries <= 0 || timeout <= 0 {
+		return timeout
+	}
+	return timeout*time.Duration(p.Retries+1) + p.maxBackoff()
+}
+
+// attemptTimeout returns the timeout of each attempt from the overall timeout, it is the inverse of Budget
+func (p RetryPolicy) attemptTimeout(budget time.Duration) time.Duration {
+	return (budget - p.maxBackoff()) / time.Duration(p.Retries+1)
+}
+
+// RequestTimeout returns the overall timeout of a request whose attempts each have the given timeout, based on the configured retries
+func RequestTimeout(timeout time.Duration) time.Duration {
+	return RetryPolicy{Retries: viper.GetInt("retries"), Backoff: viper.GetDuration("retry_backoff")}.Budget(timeout)
+}
+
+// retryTransport is an http.RoundTripper that retries transient failures
+// (connection errors, timeouts and the configured status codes) with exponential backoff and jitter
+// the deadline of the request is the overall budget (see RetryPolicy.Budget), each attempt gets its own timeout
+type retryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func newRetryTransport(next http.RoundTripper, policy RetryPolicy) http.RoundTripper {
+	if policy.Retries <= 0 {
+		return next
+	}
+	return &retryTransport{next: next, policy: policy}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var timeout time.Duration
+	if deadline, ok := req.Context().Deadline(); ok {
+		timeout = t.policy.attemptTimeout(time.Until(deadline))
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("unable to retry the request: body cannot be rewound")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("unable to retry the request: %w", err)
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		response, err := t.attempt(req, timeout)
+		if attempt >= t.policy.Retries || !t.shouldRetry(req, response, err) {
+			return response, err
+		}
+		delay := retryDelay(t.policy.Backoff, attempt, response)
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			// not enough time left to wait before the next attempt
+			return response, err
+		}
+		if response != nil {
+			_, _ = io.Copy(io.Discard, response.Body)
+			_ = response.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends the request with its own timeout, the timeout also applies to the reading of the response body
+func (t *retryTransport) attempt(req *http.Request, timeout time.Duration) (*http.Response, error) {
+	if timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	response, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+// cancelBody releases the context of an attempt when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (t *retryTransport) shouldRetry(req *http.Request, response *http.Response, err error) bool {
+	if err != nil {
+		// the request was cancelled or the overall timeout expired, another attempt won't succeed
+		if req.Context().Err() != nil {
+			return false
+		}
+		// the timeout of the attempt expired
+		if errors.Is(err, context.DeadlineExceeded) {
+			return true
+		}
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+	}
+	for _, statusCode := range t.policy.RetryOn {
+		if response.StatusCode == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before the next attempt
+// the Retry-After header sent by the server takes precedence over the exponential backoff
+func retryDelay(backoff time.Duration, attempt int, response *http.Response) time.Duration {
+	if response != nil {
+		if delay, ok := parseRetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+			return delay
+		}
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	delay := backoff << uint(attempt)
+	if delay <= 0 || delay > maxRetryBackoff {
+		// the shift overflowed or the delay is too long
+		delay = maxRetryBackoff
+	}
+	// equal jitter: wait between half and the full delay
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// parseRetryAfter parses a Retry-After header value expressed either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// ParseRetryOn parses a list of HTTP status codes, each value can also contain a comma-separated list
+// only 429 (Too Many Requests) and 5xx status codes are accepted, other 4xx responses are diagram errors
+func ParseRetryOn(values []string) ([]int, error) {
+	var statusCodes []int
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			statusCode, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retry_on status code: %s", field)
+			}
+			if statusCode != http.StatusTooManyRequests && (statusCode < 500 || statusCode > 599) {
+				return nil, fmt.Errorf("invalid retry_on status code: %d, only 429 and 5xx responses can be retried", statusCode)
+			}
+			statusCodes = append(statusCodes, statusCode)
+		}
+	}
+	return statusCodes, nil
+}
@@ -0,0 +1,214 @@
+package pkg
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	cases := []struct {
+		name             string
+		statusCodes      []int
+		retries          int
+		expectedStatus   int
+		expectedAttempts int32
+	}{
+		{
+			name:             "retry on 503 until success",
+			statusCodes:      []int{503, 502, 200},
+			retries:          3,
+			expectedStatus:   200,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "give up after the configured number of retries",
+			statusCodes:      []int{503, 503, 503, 200},
+			retries:          2,
+			expectedStatus:   503,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "retry on 429",
+			statusCodes:      []int{429, 200},
+			retries:          1,
+			expectedStatus:   200,
+			expectedAttempts: 2,
+		},
+		{
+			name:             "never retry a diagram error",
+			statusCodes:      []int{400, 200},
+			retries:          3,
+			expectedStatus:   400,
+			expectedAttempts: 1,
+		},
+	}
+	for _, c := range cases {
+		var attempts int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempt := atomic.AddInt32(&attempts, 1)
+			body := new(bytes.Buffer)
+			_, _ = body.ReadFrom(r.Body)
+			if r.Method == http.MethodPost && body.String() != "digraph G {Hello->World}" {
+				t.Errorf("RetryTransport %s error\nexpected: %s\nactual:   %s", c.name, "digraph G {Hello->World}", body.String())
+			}
+			w.WriteHeader(c.statusCodes[attempt-1])
+		}))
+		client := &http.Client{
+			Transport: newRetryTransport(http.DefaultTransport, RetryPolicy{
+				Retries: c.retries,
+				Backoff: time.Millisecond,
+				RetryOn: []int{429, 500, 502, 503, 504},
+			}),
+		}
+		response, err := client.Post(ts.URL, "text/plain", strings.NewReader("digraph G {Hello->World}"))
+		if err != nil {
+			t.Errorf("RetryTransport %s error\n%+v", c.name, err)
+		} else {
+			_ = response.Body.Close()
+			if response.StatusCode != c.expectedStatus {
+				t.Errorf("RetryTransport %s error\nexpected: %d\nactual:   %d", c.name, c.expectedStatus, response.StatusCode)
+			}
+		}
+		if attempts != c.expectedAttempts {
+			t.Errorf("RetryTransport %s error\nexpected: %d attempts\nactual:   %d attempts", c.name, c.expectedAttempts, attempts)
+		}
+		ts.Close()
+	}
+}
+
+func TestRetryTransportConnectionError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := ts.URL
+	ts.Close()
+	var attempts int32
+	countingTransport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	client := &http.Client{
+		Transport: newRetryTransport(countingTransport, RetryPolicy{Retries: 2, Backoff: time.Millisecond}),
+	}
+	_, err := client.Get(url)
+	if err == nil {
+		t.Errorf("RetryTransportConnectionError error\nexpected an error")
+	}
+	if attempts != 3 {
+		t.Errorf("RetryTransportConnectionError error\nexpected: %d attempts\nactual:   %d attempts", 3, attempts)
+	}
+}
+
+func TestRetryTransportAttemptTimeout(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			// the first attempt hangs until the client gives up
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte("<svg></svg>"))
+	}))
+	defer ts.Close()
+	policy := RetryPolicy{Retries: 1, Backoff: time.Millisecond}
+	client := &http.Client{
+		Transport: newRetryTransport(http.DefaultTransport, policy),
+		// each attempt has a timeout of 200ms
+		Timeout: policy.Budget(200 * time.Millisecond),
+	}
+	start := time.Now()
+	response, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("RetryTransportAttemptTimeout error\n%+v", err)
+	}
+	body := new(bytes.Buffer)
+	_, _ = body.ReadFrom(response.Body)
+	_ = response.Body.Close()
+	if body.String() != "<svg></svg>" {
+		t.Errorf("RetryTransportAttemptTimeout error\nexpected: %s\nactual:   %s", "<svg></svg>", body.String())
+	}
+	if attempts != 2 {
+		t.Errorf("RetryTransportAttemptTimeout error\nexpected: %d attempts\nactual:   %d attempts", 2, attempts)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("RetryTransportAttemptTimeout error\nexpected: the first attempt times out after 200ms\nactual:   %s", elapsed)
+	}
+}
+
+func TestRetryPolicyBudget(t *testing.T) {
+	cases := []struct {
+		policy   RetryPolicy
+		timeout  time.Duration
+		expected time.Duration
+	}{
+		{policy: RetryPolicy{}, timeout: 20 * time.Second, expected: 20 * time.Second},
+		{policy: RetryPolicy{Retries: 2, Backoff: 500 * time.Millisecond}, timeout: 20 * time.Second, expected: 61500 * time.Millisecond},
+		{policy: RetryPolicy{Retries: 2, Backoff: 20 * time.Second}, timeout: time.Second, expected: 3*time.Second + 50*time.Second},
+	}
+	for _, c := range cases {
+		budget := c.policy.Budget(c.timeout)
+		if budget != c.expected {
+			t.Errorf("Budget error\nexpected: %s\nactual:   %s", c.expected, budget)
+		}
+		if c.policy.Retries > 0 && c.policy.attemptTimeout(budget) != c.timeout {
+			t.Errorf("attemptTimeout error\nexpected: %s\nactual:   %s", c.timeout, c.policy.attemptTimeout(budget))
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Date(2022, 11, 8, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		retryAfter string
+		expected   time.Duration
+		ok         bool
+	}{
+		{retryAfter: "", expected: 0, ok: false},
+		{retryAfter: "3", expected: 3 * time.Second, ok: true},
+		{retryAfter: "-1", expected: 0, ok: false},
+		{retryAfter: "Tue, 08 Nov 2022 10:00:05 GMT", expected: 5 * time.Second, ok: true},
+		{retryAfter: "Tue, 08 Nov 2022 09:00:00 GMT", expected: 0, ok: true},
+		{retryAfter: "soon", expected: 0, ok: false},
+	}
+	for _, c := range cases {
+		result, ok := parseRetryAfter(c.retryAfter, now)
+		if result != c.expected || ok != c.ok {
+			t.Errorf("parseRetryAfter(%q) error\nexpected: %s (%t)\nactual:   %s (%t)", c.retryAfter, c.expected, c.ok, result, ok)
+		}
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := retryDelay(100*time.Millisecond, attempt, nil)
+		upper := 100 * time.Millisecond << uint(attempt)
+		if upper > maxRetryBackoff {
+			upper = maxRetryBackoff
+		}
+		if delay < upper/2 || delay > upper {
+			t.Errorf("retryDelay error\nexpected: between %s and %s\nactual:   %s", upper/2, upper, delay)
+		}
+	}
+}
+
+func TestParseRetryOn(t *testing.T) {
+	result, err := ParseRetryOn([]string{"429,503", " 502 "})
+	if err != nil {
+		t.Errorf("ParseRetryOn error\n%+v", err)
+	}
+	if len(result) != 3 || result[0] != 429 || result[1] != 503 || result[2] != 502 {
+		t.Errorf("ParseRetryOn error\nexpected: %v\nactual:   %v", []int{429, 503, 502}, result)
+	}
+	for _, value := range []string{"400", "404", "200", "abc"} {
+		_, err := ParseRetryOn([]string{value})
+		if err == nil {
+			t.Errorf("ParseRetryOn(%s) error\nexpected an error", value)
+		}
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
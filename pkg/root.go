@@ -34,11 +34,58 @@ var encodeCmd = &cobra.Command{
 
 var decodeCmd = &cobra.Command{
 	Use:   "decode input",
-	Short: "Decode an encoded (deflate + base64) diagram",
+	Short: "Decode an encoded (deflate + base64) diagram, a URL or an image converted using --embed-source",
 	Args:  cobra.ExactArgs(1),
 	Run:   Decode,
 }
 
+var servePreviewCmd = &cobra.Command{
+	Use:   "serve-preview [dir]",
+	Short: "Serve a live preview of the diagrams of a directory, reloaded when a file changes",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   ServePreview,
+}
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Start a caching reverse proxy of the Kroki HTTP API which forwards the requests to the configured endpoints",
+	Args:  cobra.NoArgs,
+	Run:   Proxy,
+}
+
+var mockServerCmd = &cobra.Command{
+	Use:   "mock-server",
+	Short: "Start a fake Kroki server which returns placeholder images and records the requests, for testing",
+	Args:  cobra.NoArgs,
+	Run:   MockServer,
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect, validate and initialize the configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [file]",
+	Short: "Print the effective configuration (for the given input file)",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   ConfigShow,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Validate the configuration files (that apply to the given input file)",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   ConfigValidate,
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init [file]",
+	Short: "Write a commented starter configuration file (default: kroki.yml)",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   ConfigInit,
+}
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version of kroki",
@@ -71,19 +118,96 @@ func init() {
 	}
 	sort.Strings(imageFormatNames)
 
-	typeHelp := fmt.Sprintf("diagram type %s (default: infer from file extension)", diagramTypeNames)
-	formatHelp := fmt.Sprintf("output format %s (default: infer from output file extension otherwise svg)", imageFormatNames)
+	typeHelp := fmt.Sprintf("diagram type %s (default: infer from file extension, directives or content otherwise default_type) [env KROKI_TYPE]", diagramTypeNames)
+	formatHelp := fmt.Sprintf("output format %s (default: infer from output file extension otherwise default_format) [env KROKI_FORMAT]", imageFormatNames)
 
-	convertCmd.PersistentFlags().StringP("config", "c", "", "alternate config file [env KROKI_CONFIG]")
+	RootCmd.PersistentFlags().StringP("config", "c", "", "alternate config file [env KROKI_CONFIG]")
+	RootCmd.PersistentFlags().StringP("profile", "p", "", "configuration profile (default: default_profile) [env KROKI_PROFILE]")
 	convertCmd.PersistentFlags().StringP("type", "t", "", typeHelp)
 	convertCmd.PersistentFlags().StringP("format", "f", "", formatHelp)
-	convertCmd.PersistentFlags().StringP("out-file", "o", "", "output file (default: based on path of input file); use - to output to STDOUT")
+	convertCmd.PersistentFlags().StringP("out-file", "o", "", "output file (default: based on path of input file); use - to output to STDOUT [env KROKI_OUT_FILE]")
+	convertCmd.PersistentFlags().Int("page", 0, "convert only the diagram at the given position (starting at 1) of a file containing multiple diagrams [env KROKI_PAGE]")
+	convertCmd.PersistentFlags().StringArray("var", nil, "define a variable expanded in the diagram source, for instance: --var env=prod (can be repeated)")
+	convertCmd.PersistentFlags().String("vars-file", "", "YAML, JSON or TOML file defining the variables [env KROKI_VARS_FILE]")
+	convertCmd.PersistentFlags().String("template", "", "template style used to expand the variables: shell (${NAME}), go ({{ .name }}) or none (default: shell) [env KROKI_TEMPLATE]")
+	convertCmd.PersistentFlags().Bool("strict-variables", false, "report undefined variables as an error [env KROKI_STRICT_VARIABLES]")
+	convertCmd.PersistentFlags().Bool("minify", false, "remove the comments, the metadata and the whitespace of the SVG image [env KROKI_SVG_MINIFY]")
+	convertCmd.PersistentFlags().Bool("prefix-ids", false, "prefix the IDs of the SVG image using the file name [env KROKI_SVG_PREFIX_IDS]")
+	convertCmd.PersistentFlags().Bool("accessible", false, "add role=\"img\" and a title to the SVG image [env KROKI_SVG_ACCESSIBLE]")
+	convertCmd.PersistentFlags().String("title", "", "title of the SVG image, implies --accessible (default: title of the diagram otherwise file name) [env KROKI_SVG_TITLE]")
+	convertCmd.PersistentFlags().Bool("responsive", false, "remove the fixed width and height of the SVG image [env KROKI_SVG_RESPONSIVE]")
+	convertCmd.PersistentFlags().Bool("embed-source", false, "embed the diagram source in the PNG, SVG or PDF image, recovered using kroki decode [env KROKI_EMBED_SOURCE]")
+	convertCmd.PersistentFlags().StringSlice("variants", nil, "render a theme variant of each diagram, for instance: light,dark (written to name.light.svg and name.dark.svg) [env KROKI_VARIANTS]")
+	convertCmd.PersistentFlags().Bool("picture", false, "write an HTML <picture> element selecting the light or dark variant (name.picture.html) [env KROKI_PICTURE]")
+	convertCmd.PersistentFlags().Bool("preview", false, "display the diagram in the terminal (PNG) instead of writing a file [env KROKI_PREVIEW]")
+	convertCmd.PersistentFlags().String("preview-protocol", "", "terminal graphics protocol: auto, kitty, iterm2, sixel or blocks (default: auto) [env KROKI_PREVIEW_PROTOCOL]")
+	convertCmd.PersistentFlags().Bool("no-hooks", false, "do not execute the pre and post hooks defined in the configuration [env KROKI_NO_HOOKS]")
+	convertCmd.PersistentFlags().String("depfile", "", "write a Makefile rule listing the source and included files of the output files [env KROKI_DEPFILE]")
+	convertCmd.PersistentFlags().Int("retries", 0, "number of retries on connection errors, timeouts, 429 and 5xx responses [env KROKI_RETRIES]")
+	convertCmd.PersistentFlags().Duration("retry-backoff", 0, "base delay between retries, doubled on each attempt (default: 500ms) [env KROKI_RETRY_BACKOFF]")
+	convertCmd.PersistentFlags().StringSlice("retry-on", nil, "HTTP status codes to retry (default: 429,500,502,503,504) [env KROKI_RETRY_ON]")
+	convertCmd.PersistentFlags().Bool("debug", false, "print the HTTP requests and responses, secrets are redacted [env KROKI_DEBUG]")
+	servePreviewCmd.PersistentFlags().String("listen", "", "address of the preview server (default: 127.0.0.1:8080) [env KROKI_SERVE_PREVIEW_LISTEN]")
+	servePreviewCmd.PersistentFlags().Duration("interval", 0, "delay between two scans of the directory (default: 500ms) [env KROKI_SERVE_PREVIEW_INTERVAL]")
+	proxyCmd.PersistentFlags().String("listen", "", "address of the proxy, use :8000 to accept the requests of other hosts (default: 127.0.0.1:8000) [env KROKI_PROXY_SERVER_LISTEN]")
+	proxyCmd.PersistentFlags().String("cache-dir", "", "directory of the shared cache (default: kroki in the user cache directory) [env KROKI_PROXY_SERVER_CACHE_DIR]")
+	proxyCmd.PersistentFlags().Bool("no-cache", false, "do not cache the responses [env KROKI_PROXY_SERVER_NO_CACHE]")
+	proxyCmd.PersistentFlags().Int64("max-cache-size", 0, "maximum size in bytes of the cache, the least recently used responses are removed, 0 means unlimited (default: 1073741824) [env KROKI_PROXY_SERVER_MAX_CACHE_SIZE]")
+	proxyCmd.PersistentFlags().Int64("max-request-size", 0, "maximum size in bytes of the encoded diagram or of the request body, 0 means unlimited (default: 1048576) [env KROKI_PROXY_SERVER_MAX_REQUEST_SIZE]")
+	proxyCmd.PersistentFlags().Float64("rate-limit", 0, "maximum number of requests per second and per client, 0 means unlimited [env KROKI_PROXY_SERVER_RATE_LIMIT]")
+	proxyCmd.PersistentFlags().Int("burst", 0, "maximum number of requests per client sent at once when rate limited (default: 20) [env KROKI_PROXY_SERVER_BURST]")
+	mockServerCmd.PersistentFlags().String("listen", "", "address of the mock server (default: 127.0.0.1:8001) [env KROKI_MOCK_SERVER_LISTEN]")
+	mockServerCmd.PersistentFlags().Duration("delay", 0, "delay applied to every response [env KROKI_MOCK_SERVER_DELAY]")
+	configShowCmd.Flags().Bool("resolved", false, "print the source of each setting (default, file, env or flag)")
+	configInitCmd.Flags().Bool("force", false, "overwrite the file if it already exists")
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configInitCmd)
 	RootCmd.AddCommand(versionCmd)
 	RootCmd.AddCommand(convertCmd)
 	RootCmd.AddCommand(encodeCmd)
 	RootCmd.AddCommand(decodeCmd)
+	RootCmd.AddCommand(servePreviewCmd)
+	RootCmd.AddCommand(proxyCmd)
+	RootCmd.AddCommand(mockServerCmd)
+	RootCmd.AddCommand(configCmd)
 
 	SetupConfig()
-
-	cobra.OnInitialize(InitDefaultConfig)
+	BindFlag(RootCmd, "config", "config")
+	BindFlag(RootCmd, "profile", "profile")
+	BindFlag(convertCmd, "type", "type")
+	BindFlag(convertCmd, "format", "format")
+	BindFlag(convertCmd, "out_file", "out-file")
+	BindFlag(convertCmd, "page", "page")
+	BindFlag(convertCmd, "depfile", "depfile")
+	BindFlag(convertCmd, "no_hooks", "no-hooks")
+	BindFlag(convertCmd, "svg.minify", "minify")
+	BindFlag(convertCmd, "svg.prefix_ids", "prefix-ids")
+	BindFlag(convertCmd, "svg.accessible", "accessible")
+	BindFlag(convertCmd, "svg.title", "title")
+	BindFlag(convertCmd, "svg.responsive", "responsive")
+	BindFlag(convertCmd, "embed_source", "embed-source")
+	BindFlag(convertCmd, "variants", "variants")
+	BindFlag(convertCmd, "picture", "picture")
+	BindFlag(convertCmd, "preview", "preview")
+	BindFlag(convertCmd, "preview_protocol", "preview-protocol")
+	BindFlag(convertCmd, "var", "var")
+	BindFlag(convertCmd, "vars_file", "vars-file")
+	BindFlag(convertCmd, "template", "template")
+	BindFlag(convertCmd, "strict_variables", "strict-variables")
+	BindFlag(convertCmd, "retries", "retries")
+	BindFlag(convertCmd, "retry_backoff", "retry-backoff")
+	BindFlag(convertCmd, "retry_on", "retry-on")
+	BindFlag(convertCmd, "debug", "debug")
+	BindFlag(servePreviewCmd, "serve_preview.listen", "listen")
+	BindFlag(servePreviewCmd, "serve_preview.interval", "interval")
+	BindFlag(proxyCmd, "proxy_server.listen", "listen")
+	BindFlag(proxyCmd, "proxy_server.cache_dir", "cache-dir")
+	BindFlag(proxyCmd, "proxy_server.no_cache", "no-cache")
+	BindFlag(proxyCmd, "proxy_server.max_cache_size", "max-cache-size")
+	BindFlag(proxyCmd, "proxy_server.max_request_size", "max-request-size")
+	BindFlag(proxyCmd, "proxy_server.rate_limit", "rate-limit")
+	BindFlag(proxyCmd, "proxy_server.burst", "burst")
+	BindFlag(mockServerCmd, "mock_server.listen", "listen")
+	BindFlag(mockServerCmd, "mock_server.delay", "delay")
 }
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
+
+// Route sends the diagrams matching one of the types to a specific endpoint
+type Route struct {
+	// Types contains diagram types or glob patterns (for instance: vega*)
+	Types []string `mapstructure:"types"`
+	// Endpoint overrides the configured endpoint
+	Endpoint string `mapstructure:"endpoint"`
+	// Timeout overrides the configured timeout
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Matches returns true if the diagram type matches one of the route types
+func (r Route) Matches(diagramType kroki.DiagramType) bool {
+	return matchDiagramType(r.Types, diagramType)
+}
+
+// matchDiagramType returns true if the diagram type matches one of the diagram types or glob patterns
+func matchDiagramType(types []string, diagramType kroki.DiagramType) bool {
+	for _, pattern := range types {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if !strings.ContainsAny(pattern, "*?[") {
+			// support diagram type names such as dot
+			if d, ok := getDiagramTypeNames()[pattern]; ok {
+				pattern = string(d)
+			}
+		}
+		if matched, _ := path.Match(pattern, string(diagramType)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRoutes returns the routes defined in the configuration
+func GetRoutes() ([]Route, error) {
+	var routes []Route
+	err := viper.UnmarshalKey("routes", &routes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routes: %w", err)
+	}
+	for i, route := range routes {
+		if len(route.Types) == 0 {
+			return nil, fmt.Errorf("invalid route #%d: types must not be empty", i+1)
+		}
+		for _, pattern := range route.Types {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid route #%d: malformed pattern %s", i+1, pattern)
+			}
+		}
+		if route.Endpoint == "" && route.Timeout == 0 {
+			return nil, fmt.Errorf("invalid route #%d: endpoint or timeout must be defined", i+1)
+		}
+	}
+	return routes, nil
+}
+
+// ResolveClient returns the client to use for the diagram type
+// the first route matching the diagram type wins, otherwise the default client is returned
+func ResolveClient(client kroki.Client, diagramType kroki.DiagramType) (kroki.Client, error) {
+	routes, err := GetRoutes()
+	if err != nil {
+		return client, err
+	}
+	for _, route := range routes {
+		if route.Matches(diagramType) {
+			config := client.Config
+			if route.Endpoint != "" {
+				config.URL = ResolveEndpoint(route.Endpoint)
+			}
+			if route.Timeout > 0 {
+				config.Timeout = RequestTimeout(route.Timeout)
+			}
+			return kroki.New(config), nil
+		}
+	}
+	return client, nil
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
+
+func TestResolveClient(t *testing.T) {
+	viper.Set("routes", []map[string]interface{}{
+		{
+			"types":    []string{"mermaid", "bpmn", "excalidraw", "diagramsnet"},
+			"endpoint": "https://companion.kroki.example.com",
+			"timeout":  "1m",
+		},
+		{
+			"types":    "vega*",
+			"endpoint": "https://vega.kroki.example.com",
+		},
+		{
+			"types":   []string{"dot"},
+			"timeout": "5s",
+		},
+	})
+	defer viper.Set("routes", nil)
+	client := kroki.New(kroki.Configuration{
+		URL:     "https://kroki.example.com",
+		Timeout: 20 * time.Second,
+	})
+	cases := []struct {
+		diagramType kroki.DiagramType
+		expected    kroki.Configuration
+	}{
+		{
+			diagramType: kroki.Mermaid,
+			expected:    kroki.Configuration{URL: "https://companion.kroki.example.com", Timeout: time.Minute},
+		},
+		{
+			diagramType: kroki.VegaLite,
+			expected:    kroki.Configuration{URL: "https://vega.kroki.example.com", Timeout: 20 * time.Second},
+		},
+		{
+			diagramType: kroki.GraphViz,
+			expected:    kroki.Configuration{URL: "https://kroki.example.com", Timeout: 5 * time.Second},
+		},
+		{
+			diagramType: kroki.PlantUML,
+			expected:    kroki.Configuration{URL: "https://kroki.example.com", Timeout: 20 * time.Second},
+		},
+	}
+	for _, c := range cases {
+		result, err := ResolveClient(client, c.diagramType)
+		if err != nil {
+			t.Errorf("ResolveClient error\n%+v", err)
+		}
+		if result.Config != c.expected {
+			t.Errorf("ResolveClient(%s) error\nexpected: %+v\nactual:   %+v", c.diagramType, c.expected, result.Config)
+		}
+	}
+}
+
+func TestGetRoutesInvalid(t *testing.T) {
+	cases := []interface{}{
+		[]map[string]interface{}{{"endpoint": "https://kroki.example.com"}},
+		[]map[string]interface{}{{"types": []string{"mermaid"}}},
+		[]map[string]interface{}{{"types": []string{"[mermaid"}, "timeout": "1m"}},
+		"mermaid",
+	}
+	defer viper.Set("routes", nil)
+	for _, c := range cases {
+		viper.Set("routes", c)
+		_, err := GetRoutes()
+		if err == nil {
+			t.Errorf("GetRoutes(%v) error\nexpected an error", c)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
+
+// PreviewServer serves a live preview of the diagrams of a directory, the browsers are notified of the changes using Server-Sent Events
+type PreviewServer struct {
+	Dir string
+	// Interval is the delay between two scans of the directory
+	Interval time.Duration
+	// Render returns the SVG images of a diagram file, it is never called concurrently
+	Render func(filePath string) ([]string, error)
+	// IsDiagram returns true if the file is a diagram, it is never called concurrently with Render
+	IsDiagram func(filePath string) bool
+
+	// mutex protects the global configuration used by Render and IsDiagram
+	mutex       sync.Mutex
+	clientMutex sync.Mutex
+	clients     map[chan string]bool
+}
+
+// NewPreviewServer returns a server which renders the diagram files of the directory
+func NewPreviewServer(dir string, interval time.Duration, render func(filePath string) ([]string, error)) *PreviewServer {
+	return &PreviewServer{
+		Dir:      dir,
+		Interval: interval,
+		Render:   render,
+		IsDiagram: func(filePath string) bool {
+			_, err := GraphFormatFromFile(filePath)
+			return err == nil
+		},
+		clients: map[chan string]bool{},
+	}
+}
+
+// skipDir returns true if the directory is not scanned (hidden directories and dependencies)
+func skipDir(name string) bool {
+	return name != "." && (strings.HasPrefix(name, ".") || name == "node_modules")
+}
+
+// walkFiles calls fn with the path (relative to dir, using forward slashes) of each file of the directory
+func walkFiles(dir string, fn func(name string, info os.FileInfo)) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if file != dir && skipDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		fn(filepath.ToSlash(name), info)
+		return nil
+	})
+}
+
+// Diagrams returns the diagram files of the directory sorted by path
+func (s *PreviewServer) Diagrams() ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var diagrams []string
+	err := walkFiles(s.Dir, func(name string, _ os.FileInfo) {
+		if s.IsDiagram(name) {
+			diagrams = append(diagrams, name)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fail to read directory %s: %w", s.Dir, err)
+	}
+	sort.Strings(diagrams)
+	return diagrams, nil
+}
+
+// snapshot returns the modification time and the size of each file of the directory,
+// all the files are watched since a diagram can include another file or be configured by a configuration file
+func (s *PreviewServer) snapshot() map[string]string {
+	files := map[string]string{}
+	_ = walkFiles(s.Dir, func(name string, info os.FileInfo) {
+		files[name] = fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	})
+	return files
+}
+
+// Watch scans the directory at regular intervals and notifies the browsers when a file is created, modified or deleted,
+// it returns when the stop channel is closed
+func (s *PreviewServer) Watch(stop <-chan struct{}) {
+	previous := s.snapshot()
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current := s.snapshot()
+			if changed := changedFiles(previous, current); len(changed) > 0 {
+				s.broadcast(changed[0])
+			}
+			previous = current
+		}
+	}
+}
+
+// changedFiles returns the files created, modified or deleted between two snapshots
+func changedFiles(previous map[string]string, current map[string]string) []string {
+	var changed []string
+	for name, state := range current {
+		if previous[name] != state {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func (s *PreviewServer) subscribe() chan string {
+	s.clientMutex.Lock()
+	defer s.clientMutex.Unlock()
+	client := make(chan string, 1)
+	s.clients[client] = true
+	return client
+}
+
+func (s *PreviewServer) unsubscribe(client chan string) {
+	s.clientMutex.Lock()
+	defer s.clientMutex.Unlock()
+	delete(s.clients, client)
+}
+
+func (s *PreviewServer) broadcast(name string) {
+	s.clientMutex.Lock()
+	defer s.clientMutex.Unlock()
+	for client := range s.clients {
+		select {
+		case client <- name:
+		default:
+			// a change is already pending, the page will be reloaded anyway
+		}
+	}
+}
+
+func (s *PreviewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/":
+		s.serveIndex(w)
+	case r.URL.Path == "/events":
+		s.serveEvents(w, r)
+	case strings.HasPrefix(r.URL.Path, "/view/"):
+		s.serveDiagram(w, strings.TrimPrefix(r.URL.Path, "/view/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveEvents sends a change event when a file of the directory changes
+func (s *PreviewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	client := s.subscribe()
+	defer s.unsubscribe(client)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, "retry: 1000\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case name := <-client:
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", name)
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *PreviewServer) serveIndex(w http.ResponseWriter) {
+	diagrams, err := s.Diagrams()
+	data := previewPage{Title: s.Dir, Diagrams: diagrams}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	writePreviewPage(w, data)
+}
+
+func (s *PreviewServer) serveDiagram(w http.ResponseWriter, name string) {
+	name = path.Clean("/" + name)[1:]
+	diagrams, err := s.Diagrams()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// only the diagrams of the directory can be rendered
+	if !containsString(diagrams, name) {
+		http.Error(w, "diagram not found: "+name, http.StatusNotFound)
+		return
+	}
+	data := previewPage{Title: name, Name: name}
+	s.mutex.Lock()
+	images, err := s.Render(filepath.Join(s.Dir, filepath.FromSlash(name)))
+	s.mutex.Unlock()
+	if err != nil {
+		// the error is displayed in the page which is reloaded when the file is fixed
+		data.Error = err.Error()
+	}
+	for _, image := range images {
+		data.Images = append(data.Images, template.URL("data:image/svg+xml;base64,"+base64.StdEncoding.EncodeToString([]byte(image))))
+	}
+	writePreviewPage(w, data)
+}
+
+type previewPage struct {
+	Title    string
+	Name     string
+	Diagrams []string
+	Images   []template.URL
+	Error    string
+}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }} - Kroki preview</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+img { display: block; max-width: 100%; margin-bottom: 2em; }
+.error { color: #b00020; background: #fdecea; padding: 1em; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+{{- if .Name }}
+<p><a href="/">All diagrams</a></p>
+<h1>{{ .Name }}</h1>
+{{- else }}
+<h1>{{ .Title }}</h1>
+<ul>
+{{- range .Diagrams }}
+<li><a href="/view/{{ . }}">{{ . }}</a></li>
+{{- else }}
+<li>No diagram found</li>
+{{- end }}
+</ul>
+{{- end }}
+{{- if .Error }}
+<pre class="error">{{ .Error }}</pre>
+{{- end }}
+{{- range .Images }}
+<img src="{{ . }}" alt="{{ $.Name }}">
+{{- end }}
+<script>
+new EventSource("/events").addEventListener("change", function () { location.reload(); });
+</script>
+</body>
+</html>
+`))
+
+func writePreviewPage(w http.ResponseWriter, data previewPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	err := previewTemplate.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// previewTransport is the transport used to render the previews, it is only rebuilt when the configuration changes
+// so that the connections and the state of the endpoints (failover, circuit breaker) are kept between the renders
+var previewTransport struct {
+	settings  string
+	transport http.RoundTripper
+}
+
+// cachedPreviewTransport returns the transport of the current configuration, the renders are never concurrent
+func cachedPreviewTransport() (http.RoundTripper, error) {
+	// the keys of the maps are sorted by fmt
+	settings := fmt.Sprint(viper.AllSettings())
+	if previewTransport.transport != nil && previewTransport.settings == settings {
+		return previewTransport.transport, nil
+	}
+	transport, err := NewTransport()
+	if err != nil {
+		return nil, err
+	}
+	previewTransport.settings = settings
+	previewTransport.transport = transport
+	return transport, nil
+}
+
+// RenderPreviewFile converts the diagram file to SVG using the configuration that applies to the file,
+// like the convert command but the errors are returned
+func RenderPreviewFile(cmd *cobra.Command, filePath string) ([]string, error) {
+	err := LoadCommandConfig(cmd, filePath)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := cachedPreviewTransport()
+	if err != nil {
+		return nil, err
+	}
+	http.DefaultClient.Transport = transport
+	client := kroki.New(kroki.Configuration{URL: Endpoints()[0], Timeout: RequestTimeout(viper.GetDuration("timeout"))})
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read file '%s': %w", filePath, err)
+	}
+	conversion, err := NewConversion(client, string(content), filePath, "", string(kroki.SVG), "")
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	for _, diagram := range conversion.Diagrams {
+		for _, variant := range conversion.RenderedVariants() {
+			image, err := conversion.Render(diagram, variant, "")
+			if err != nil {
+				return images, err
+			}
+			images = append(images, string(image))
+		}
+	}
+	return images, nil
+}
+
+// ServePreview starts the preview server of the directory (the working directory by default)
+func ServePreview(cmd *cobra.Command, args []string) {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		exit(fmt.Errorf("%s is not a directory", dir))
+	}
+	// the trailing separator makes the directory the starting point of the configuration discovery
+	err := LoadCommandConfig(cmd, dir+string(filepath.Separator))
+	if err != nil {
+		exit(err)
+	}
+	interval := viper.GetDuration("serve_preview.interval")
+	if interval <= 0 {
+		exit(fmt.Errorf("invalid serve_preview.interval: %s, expected a positive duration", interval))
+	}
+	server := NewPreviewServer(dir, interval, func(filePath string) ([]string, error) {
+		return RenderPreviewFile(cmd, filePath)
+	})
+	go server.Watch(make(chan struct{}))
+	address := viper.GetString("serve_preview.listen")
+	fmt.Fprintf(os.Stderr, "Serving the diagrams of %s on http://%s (press Ctrl+C to stop)\n", dir, address)
+	httpServer := &http.Server{Addr: address, Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	exit(httpServer.ListenAndServe())
+}
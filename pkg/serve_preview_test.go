@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-cli/pkg/mock"
+)
+
+func newTestPreviewServer(t *testing.T) (*PreviewServer, string) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"login.puml":        "@startuml\nAlice -> Bob\n@enduml",
+		"sub/network.d2":    "a -> b",
+		"broken.puml":       "@startuml\nAlice ->\n@enduml",
+		"README.md":         "# Diagrams",
+		".git/HEAD.puml":    "ignored",
+		"node_modules/x.d2": "ignored",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := NewPreviewServer(dir, 10*time.Millisecond, func(filePath string) ([]string, error) {
+		if filepath.Base(filePath) == "broken.puml" {
+			return nil, errors.New("Syntax Error? (line: 2) <Alice>")
+		}
+		return []string{"<svg/>"}, nil
+	})
+	server.IsDiagram = func(filePath string) bool {
+		return strings.HasSuffix(filePath, ".puml") || strings.HasSuffix(filePath, ".d2")
+	}
+	return server, dir
+}
+
+func serve(handler http.Handler, target string) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestPreviewServerDiagrams(t *testing.T) {
+	server, _ := newTestPreviewServer(t)
+	diagrams, err := server.Diagrams()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := "broken.puml, login.puml, sub/network.d2"
+	if strings.Join(diagrams, ", ") != expected {
+		t.Errorf("Diagrams error\nexpected: %s\nactual:   %s", expected, strings.Join(diagrams, ", "))
+	}
+	_, body := serve(server, "/")
+	if !strings.Contains(body, `<a href="/view/sub/network.d2">sub/network.d2</a>`) || !strings.Contains(body, `new EventSource("/events")`) {
+		t.Errorf("index error, expected the list of diagrams and the reload script\nactual:   %s", body)
+	}
+}
+
+func TestPreviewServerView(t *testing.T) {
+	server, _ := newTestPreviewServer(t)
+	cases := []struct {
+		target   string
+		code     int
+		expected string
+	}{
+		{target: "/view/login.puml", code: http.StatusOK, expected: `<img src="data:image/svg&#43;xml;base64,PHN2Zy8&#43;" alt="login.puml">`},
+		// the error is displayed in the page (escaped)
+		{target: "/view/broken.puml", code: http.StatusOK, expected: `<pre class="error">Syntax Error? (line: 2) &lt;Alice&gt;</pre>`},
+		{target: "/view/README.md", code: http.StatusNotFound, expected: "diagram not found: README.md"},
+		{target: "/view/../../etc/hosts.puml", code: http.StatusNotFound, expected: "diagram not found: etc/hosts.puml"},
+		{target: "/view/.git/HEAD.puml", code: http.StatusNotFound, expected: "diagram not found: .git/HEAD.puml"},
+	}
+	for _, c := range cases {
+		code, body := serve(server, c.target)
+		if code != c.code || !strings.Contains(body, c.expected) {
+			t.Errorf("GET %s error\nexpected: %d %s\nactual:   %d %s", c.target, c.code, c.expected, code, body)
+		}
+	}
+}
+
+func TestPreviewServerEvents(t *testing.T) {
+	server, dir := newTestPreviewServer(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	go server.Watch(stop)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	response, err := http.Get(httpServer.URL + "/events")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer response.Body.Close()
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Content-Type error\nexpected: text/event-stream\nactual:   %s", contentType)
+	}
+	reader := bufio.NewReader(response.Body)
+	// the retry field is sent when the client is subscribed
+	if line, _ := reader.ReadString('\n'); line != "retry: 1000\n" {
+		t.Fatalf("unexpected line: %q", line)
+	}
+	err = os.WriteFile(filepath.Join(dir, "sub", "common.d2"), []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan string, 1)
+	go func() {
+		var lines []string
+		for len(lines) < 2 {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line != "\n" {
+				lines = append(lines, line)
+			}
+		}
+		events <- strings.Join(lines, "")
+	}()
+	select {
+	case event := <-events:
+		expected := "event: change\ndata: sub/common.d2\n"
+		if event != expected {
+			t.Errorf("event error\nexpected: %q\nactual:   %q", expected, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("no event received after the file was created")
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	previous := map[string]string{"a.puml": "1", "b.puml": "1", "c.puml": "1"}
+	current := map[string]string{"a.puml": "1", "b.puml": "2", "d.puml": "1"}
+	result := strings.Join(changedFiles(previous, current), ", ")
+	expected := "b.puml, c.puml, d.puml"
+	if result != expected {
+		t.Errorf("changedFiles error\nexpected: %s\nactual:   %s", expected, result)
+	}
+}
+
+func TestCachedPreviewTransport(t *testing.T) {
+	defer viper.Set("retries", nil)
+	first, err := cachedPreviewTransport()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	second, _ := cachedPreviewTransport()
+	if first != second {
+		t.Errorf("cachedPreviewTransport error: the transport must be reused when the configuration does not change")
+	}
+	viper.Set("retries", 2)
+	third, _ := cachedPreviewTransport()
+	if third == first {
+		t.Errorf("cachedPreviewTransport error: the transport must be rebuilt when the configuration changes")
+	}
+}
+
+func TestRenderPreviewFileVariantsAndEmbeddedSource(t *testing.T) {
+	server := mock.New()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	defer func() { _ = LoadConfigFiles(nil) }()
+	defer func(transport http.RoundTripper) { http.DefaultClient.Transport = transport }(http.DefaultClient.Transport)
+	dir := t.TempDir()
+	config := "endpoint: " + ts.URL + "\nvariants: [light, dark]\nembed_source: true\n"
+	if err := os.WriteFile(filepath.Join(dir, "kroki.yml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(dir, "hello.dot")
+	if err := os.WriteFile(filePath, []byte("digraph G {Hello->World}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	images, err := RenderPreviewFile(servePreviewCmd, filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	// the preview contains the same images as the convert command: one image per variant with the embedded source
+	if len(images) != 2 {
+		t.Fatalf("RenderPreviewFile error\nexpected: %d images\nactual:   %d", 2, len(images))
+	}
+	for _, image := range images {
+		embedded, err := ExtractSource([]byte(image))
+		if err != nil || embedded.DiagramType != "graphviz" {
+			t.Errorf("RenderPreviewFile error\nexpected: the embedded graphviz source\nactual:   %s (%v)", image, err)
+		}
+	}
+	requests := server.Requests()
+	if len(requests) != 2 || !strings.Contains(requests[1].Source, `bgcolor="#0d1117"`) {
+		t.Errorf("RenderPreviewFile error\nexpected: the dark variant to be requested\nactual:   %+v", requests)
+	}
+}
@@ -0,0 +1,214 @@
+package pkg
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/yuzutech/kroki-go"
+)
+
+// Confidence indicates how confident the content sniffer is about the diagram type
+type Confidence int
+
+const (
+	// NoConfidence means that the diagram type could not be inferred from the content
+	NoConfidence Confidence = iota
+	// LowConfidence means that the content looks like the diagram type but other diagram types use a similar syntax
+	LowConfidence
+	// MediumConfidence means that the content contains constructs specific to the diagram type
+	MediumConfidence
+	// HighConfidence means that the content starts with a keyword or a schema that identifies the diagram type
+	HighConfidence
+)
+
+func (c Confidence) String() string {
+	switch c {
+	case LowConfidence:
+		return "low"
+	case MediumConfidence:
+		return "medium"
+	case HighConfidence:
+		return "high"
+	}
+	return "none"
+}
+
+// mermaidKeywords are the keywords that start a Mermaid diagram
+var mermaidKeywords = []string{
+	"graph", "flowchart", "sequencediagram", "classdiagram", "statediagram", "statediagram-v2", "erdiagram",
+	"journey", "gantt", "pie", "gitgraph", "mindmap", "timeline", "quadrantchart", "requirementdiagram",
+	"c4context", "c4container", "c4component", "c4dynamic", "c4deployment", "sankey-beta", "xychart-beta",
+	"block-beta", "packet-beta", "architecture-beta", "kanban",
+}
+
+var (
+	graphvizPattern    = regexp.MustCompile(`(?i)^(strict\s+)?(di)?graph(\s+("[^"]*"|[\w.]+))?\s*\{`)
+	blockdiagPattern   = regexp.MustCompile(`^(blockdiag|seqdiag|actdiag|nwdiag|packetdiag|rackdiag)(\s+[\w"]+)?\s*\{`)
+	structurizrPattern = regexp.MustCompile(`^workspace(\s+extends\s+\S+|\s+"[^"]*")*\s*\{`)
+	dbmlPattern        = regexp.MustCompile(`(?mi)^\s*table\s+[\w."]+(\s+as\s+\w+)?\s*(\[[^\]]*\])?\s*\{`)
+	erdEntityPattern   = regexp.MustCompile(`^\[[\w\s"]+\]\s*(\{.*\})?$`)
+	erdKeyPattern      = regexp.MustCompile(`(?m)^\s*[*+]\s*\w`)
+	nomnomlPattern     = regexp.MustCompile(`^\[[^\]]+\]\s*([-<>:o+]+)\s*\[`)
+	pikchrPattern      = regexp.MustCompile(`^(box|circle|ellipse|arrow|line|oval|cylinder|file|dot|text|move|arc|spline)\b`)
+	d2DirectivePattern = regexp.MustCompile(`(?m)^\s*(direction:\s*(up|down|left|right)|[\w.]*\bshape:\s*\w+)\s*$`)
+	d2EdgePattern      = regexp.MustCompile(`(?m)^\s*[\w."' -]+?\s*(<->|->|<-|--)\s*[\w."' -]+(:.*)?$`)
+	nomnomlDirective   = regexp.MustCompile(`^#[\w ]+:`)
+	commentPattern     = regexp.MustCompile(`^(//|%%|'|#(\s|$|[^\w]))`)
+)
+
+// SniffDiagramType infers the diagram type from the content of a diagram
+// and returns how confident the match is (NoConfidence when the content is not recognized)
+func SniffDiagramType(text string) (kroki.DiagramType, Confidence) {
+	text = strings.TrimSpace(strings.TrimPrefix(text, "\ufeff"))
+	if text == "" {
+		return "", NoConfidence
+	}
+	switch text[0] {
+	case '{', '[':
+		if diagramType, confidence := sniffJSON(text); confidence != NoConfidence {
+			return diagramType, confidence
+		}
+	case '<':
+		return sniffXML(text)
+	}
+	return sniffText(text)
+}
+
+func sniffJSON(text string) (kroki.DiagramType, Confidence) {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &document); err != nil {
+		// WaveDrom uses JSON5, keys are not always quoted
+		if strings.Contains(text, "signal") && strings.Contains(text, "wave") {
+			return kroki.WaveDrom, MediumConfidence
+		}
+		return "", NoConfidence
+	}
+	if documentType, ok := document["type"].(string); ok && documentType == "excalidraw" {
+		return kroki.Excalidraw, HighConfidence
+	}
+	if schema, ok := document["$schema"].(string); ok {
+		if strings.Contains(schema, "vega-lite") {
+			return kroki.VegaLite, HighConfidence
+		}
+		if strings.Contains(schema, "schema/vega/") {
+			return kroki.Vega, HighConfidence
+		}
+	}
+	if _, ok := document["signal"]; ok {
+		return kroki.WaveDrom, HighConfidence
+	}
+	if _, ok := document["elements"]; ok {
+		if _, ok := document["appState"]; ok {
+			return kroki.Excalidraw, MediumConfidence
+		}
+	}
+	if _, ok := document["mark"]; ok {
+		return kroki.VegaLite, MediumConfidence
+	}
+	if _, ok := document["marks"]; ok {
+		return kroki.Vega, MediumConfidence
+	}
+	return "", NoConfidence
+}
+
+func sniffXML(text string) (kroki.DiagramType, Confidence) {
+	switch {
+	case strings.Contains(text, "http://www.omg.org/spec/BPMN/20100524/MODEL"):
+		return kroki.BPMN, HighConfidence
+	case strings.Contains(text, "<mxfile") || strings.Contains(text, "<mxGraphModel"):
+		return kroki.Diagramsnet, HighConfidence
+	case strings.Contains(text, "<umlet_diagram") || strings.Contains(text, `program="umlet"`):
+		return kroki.UMlet, HighConfidence
+	case strings.Contains(text, "bpmn:definitions") || strings.Contains(text, "<bpmn2:definitions"):
+		return kroki.BPMN, MediumConfidence
+	}
+	return "", NoConfidence
+}
+
+func sniffText(text string) (kroki.DiagramType, Confidence) {
+	statement := firstStatement(text)
+	if statement == "" {
+		return "", NoConfidence
+	}
+	// the content starting at the first statement, the opening brace can be on the next line
+	rest := text[strings.Index(text, statement):]
+	lower := strings.ToLower(statement)
+	if strings.HasPrefix(lower, "@start") {
+		switch {
+		case strings.HasPrefix(lower, "@startditaa"):
+			return kroki.Ditaa, HighConfidence
+		case strings.Contains(text, "C4_") || strings.Contains(text, "<C4/"):
+			return kroki.C4PlantUML, HighConfidence
+		}
+		return kroki.PlantUML, HighConfidence
+	}
+	if graphvizPattern.MatchString(rest) {
+		return kroki.GraphViz, HighConfidence
+	}
+	keyword := strings.ToLower(strings.Fields(statement)[0])
+	if containsString(mermaidKeywords, keyword) {
+		return kroki.Mermaid, HighConfidence
+	}
+	if blockdiagPattern.MatchString(rest) {
+		return kroki.DiagramType(strings.Fields(strings.Replace(rest, "{", " ", 1))[0]), HighConfidence
+	}
+	if structurizrPattern.MatchString(rest) {
+		return kroki.Structurizr, HighConfidence
+	}
+	if strings.HasPrefix(statement, `\documentclass`) || strings.HasPrefix(statement, `\begin{tikzpicture}`) ||
+		strings.HasPrefix(statement, `\usetikzlibrary`) {
+		return "tikz", HighConfidence
+	}
+	if strings.HasPrefix(statement, "(def") || strings.HasPrefix(statement, "(draw-") {
+		return kroki.Bytefield, MediumConfidence
+	}
+	if dbmlPattern.MatchString(text) {
+		return "dbml", MediumConfidence
+	}
+	if nomnomlDirective.MatchString(statement) {
+		// nomnoml directives, for instance: #direction: right
+		return kroki.Nomnoml, MediumConfidence
+	}
+	if nomnomlPattern.MatchString(statement) {
+		return kroki.Nomnoml, MediumConfidence
+	}
+	if erdEntityPattern.MatchString(statement) {
+		if erdKeyPattern.MatchString(text) {
+			return kroki.Erd, MediumConfidence
+		}
+		return kroki.Erd, LowConfidence
+	}
+	if strings.HasPrefix(statement, "diagram {") {
+		return kroki.BlockDiag, LowConfidence
+	}
+	if d2DirectivePattern.MatchString(text) {
+		return kroki.D2, MediumConfidence
+	}
+	if pikchrPattern.MatchString(statement) {
+		return kroki.Pikchr, LowConfidence
+	}
+	if d2EdgePattern.MatchString(text) {
+		return kroki.D2, LowConfidence
+	}
+	return "", NoConfidence
+}
+
+// firstStatement returns the first line that is not empty, a comment or a front matter
+func firstStatement(text string) string {
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if i == 0 && line == "---" {
+			// skip the front matter (used by Mermaid to define the title or the configuration)
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "---"; i++ {
+			}
+			continue
+		}
+		if line == "" || commentPattern.MatchString(line) {
+			continue
+		}
+		return line
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
+
+func TestSniffDiagramType(t *testing.T) {
+	cases := []struct {
+		text       string
+		expected   kroki.DiagramType
+		confidence Confidence
+	}{
+		{text: "@startuml\nAlice -> Bob: hello\n@enduml", expected: kroki.PlantUML, confidence: HighConfidence},
+		{text: "' comment\n@startuml\n!include <C4/C4_Context>\nPerson(user, \"User\")\n@enduml", expected: kroki.C4PlantUML, confidence: HighConfidence},
+		{text: "@startditaa\n+---+\n| A |\n+---+\n@endditaa", expected: kroki.Ditaa, confidence: HighConfidence},
+		{text: "digraph G {\n  Hello -> World\n}", expected: kroki.GraphViz, confidence: HighConfidence},
+		{text: "// comment\nstrict graph\n{\n  a -- b\n}", expected: kroki.GraphViz, confidence: HighConfidence},
+		{text: "graph TD\n  A --> B", expected: kroki.Mermaid, confidence: HighConfidence},
+		{text: "---\ntitle: Flow\n---\nflowchart LR\n  A --> B", expected: kroki.Mermaid, confidence: HighConfidence},
+		{text: "%% comment\nsequenceDiagram\n  Alice->>Bob: hello", expected: kroki.Mermaid, confidence: HighConfidence},
+		{text: "erDiagram\n  CUSTOMER ||--o{ ORDER : places", expected: kroki.Mermaid, confidence: HighConfidence},
+		{text: "seqdiag {\n  browser -> webserver;\n}", expected: kroki.SeqDiag, confidence: HighConfidence},
+		{text: "workspace {\n  model {\n  }\n}", expected: kroki.Structurizr, confidence: HighConfidence},
+		{text: `<?xml version="1.0" encoding="UTF-8"?><definitions xmlns="http://www.omg.org/spec/BPMN/20100524/MODEL"></definitions>`, expected: kroki.BPMN, confidence: HighConfidence},
+		{text: `<mxfile host="app.diagrams.net"></mxfile>`, expected: kroki.Diagramsnet, confidence: HighConfidence},
+		{text: `{"type": "excalidraw", "version": 2, "elements": []}`, expected: kroki.Excalidraw, confidence: HighConfidence},
+		{text: `{"$schema": "https://vega.github.io/schema/vega-lite/v5.json", "mark": "bar"}`, expected: kroki.VegaLite, confidence: HighConfidence},
+		{text: `{"$schema": "https://vega.github.io/schema/vega/v5.json", "marks": []}`, expected: kroki.Vega, confidence: HighConfidence},
+		{text: `{"signal": [{"name": "clk", "wave": "p...."}]}`, expected: kroki.WaveDrom, confidence: HighConfidence},
+		{text: `{ signal: [{ name: "clk", wave: "p...." }] }`, expected: kroki.WaveDrom, confidence: MediumConfidence},
+		{text: "(draw-column-headers)\n(draw-box \"Address\" {:span 4})", expected: kroki.Bytefield, confidence: MediumConfidence},
+		{text: "#direction: right\n[Hello] -> [World]", expected: kroki.Nomnoml, confidence: MediumConfidence},
+		{text: "[Person]\n*name\nheight", expected: kroki.Erd, confidence: MediumConfidence},
+		{text: "direction: right\nx -> y", expected: kroki.D2, confidence: MediumConfidence},
+		{text: "x -> y: hello", expected: kroki.D2, confidence: LowConfidence},
+		{text: "box \"Hello\"\narrow", expected: kroki.Pikchr, confidence: LowConfidence},
+		{text: "Hello world", expected: "", confidence: NoConfidence},
+		{text: "", expected: "", confidence: NoConfidence},
+	}
+	for _, c := range cases {
+		result, confidence := SniffDiagramType(c.text)
+		if result != c.expected || confidence != c.confidence {
+			t.Errorf("SniffDiagramType(%q) error\nexpected: %s (%s)\nactual:   %s (%s)", c.text, c.expected, c.confidence, result, confidence)
+		}
+	}
+}
+
+func TestResolveGraphFormatFromContent(t *testing.T) {
+	text := "sequenceDiagram\n  Alice->>Bob: hello"
+	result, err := ResolveGraphFormat("", "diagram.txt", text)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if result != kroki.Mermaid {
+		t.Errorf("ResolveGraphFormat error\nexpected: %s\nactual:   %s", kroki.Mermaid, result)
+	}
+	// the --type flag takes precedence over the content
+	result, _ = ResolveGraphFormat("plantuml", "diagram.txt", text)
+	if result != kroki.PlantUML {
+		t.Errorf("ResolveGraphFormat error\nexpected: %s\nactual:   %s", kroki.PlantUML, result)
+	}
+	// the error is reported when the diagram type cannot be inferred
+	_, err = ResolveGraphFormat("", "", "hello")
+	if err == nil || !strings.Contains(err.Error(), "unable to infer the diagram type from the content") {
+		t.Errorf("ResolveGraphFormat error\nexpected: unable to infer the diagram type from the content\nactual:   %v", err)
+	}
+}
+
+func TestInferGraphFormatDefaultType(t *testing.T) {
+	viper.Set("default_type", "plantuml")
+	defer viper.Set("default_type", nil)
+	// a low confidence match is ignored when a default type is defined
+	result, _ := InferGraphFormat("x -> y: hello")
+	if result != kroki.PlantUML {
+		t.Errorf("InferGraphFormat error\nexpected: %s\nactual:   %s", kroki.PlantUML, result)
+	}
+	result, _ = InferGraphFormat("graph LR\n  A --> B")
+	if result != kroki.Mermaid {
+		t.Errorf("InferGraphFormat error\nexpected: %s\nactual:   %s", kroki.Mermaid, result)
+	}
+}
@@ -0,0 +1,191 @@
+package pkg
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// SVGOptions contains the transformations applied to the SVG images, all of them are opt-in
+type SVGOptions struct {
+	// Minify removes the comments, the metadata and the whitespace between the elements
+	Minify bool
+	// PrefixIDs prefixes the IDs (and their references) to avoid collisions when multiple images are inlined in a page
+	PrefixIDs bool
+	// Prefix is used when PrefixIDs is enabled, derived from the file name by default
+	Prefix string
+	// Accessible adds role="img" and a title to the root element
+	Accessible bool
+	// Title overrides the title of the image, implies Accessible
+	Title string
+	// DefaultTitle is used when the title is not defined and the image does not have a title (for instance: the file name)
+	DefaultTitle string
+	// Responsive removes the fixed width and height of the root element (a viewBox is added if needed)
+	Responsive bool
+}
+
+// GetSVGOptions returns the SVG transformations defined in the configuration
+func GetSVGOptions() SVGOptions {
+	return SVGOptions{
+		Minify:     viper.GetBool("svg.minify"),
+		PrefixIDs:  viper.GetBool("svg.prefix_ids"),
+		Accessible: viper.GetBool("svg.accessible"),
+		Title:      viper.GetString("svg.title"),
+		Responsive: viper.GetBool("svg.responsive"),
+	}
+}
+
+// Enabled returns true if at least one transformation is enabled
+func (o SVGOptions) Enabled() bool {
+	return o.Minify || o.PrefixIDs || o.Accessible || o.Title != "" || o.Responsive
+}
+
+var (
+	svgRootPattern      = regexp.MustCompile(`<svg\b[^>]*>`)
+	svgCommentPattern   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	svgMetadataPattern  = regexp.MustCompile(`(?s)<metadata\b[^>]*?(/>|>.*?</metadata>)`)
+	svgBlankPattern     = regexp.MustCompile(`>\s*\n\s*<`)
+	svgIDPattern        = regexp.MustCompile(`(\s)id="([^"]+)"`)
+	svgReferencePattern = regexp.MustCompile(`(url\(\s*['"]?#|href="#)([^'")\s]+)`)
+	svgStylePattern     = regexp.MustCompile(`(?s)(<style\b[^>]*>)(.*?)(</style>)`)
+	svgSelectorPattern  = regexp.MustCompile(`#([\w-]+)`)
+	svgTitlePattern     = regexp.MustCompile(`^\s*<title\b[^>]*>(.*?)</title>`)
+	svgSizePattern      = regexp.MustCompile(`\s(width|height)="([^"]*)"`)
+	svgSizeValuePattern = regexp.MustCompile(`^([\d.]+)(px)?$`)
+	titlePattern        = regexp.MustCompile(`(?mi)^\s*(?:pie\s+)?title:?\s+(.+?)\s*$`)
+	unsafeIDPattern     = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+)
+
+// TransformSVG applies the transformations to the SVG image
+func TransformSVG(svg string, options SVGOptions) string {
+	if options.Minify {
+		svg = svgCommentPattern.ReplaceAllString(svg, "")
+		svg = svgMetadataPattern.ReplaceAllString(svg, "")
+		svg = strings.TrimSpace(svgBlankPattern.ReplaceAllString(svg, "><"))
+	}
+	if options.PrefixIDs {
+		svg = prefixIDs(svg, options.Prefix)
+	}
+	if options.Responsive {
+		svg = svgRootPattern.ReplaceAllStringFunc(svg, responsiveRoot)
+	}
+	if options.Accessible || options.Title != "" {
+		prefix := options.Prefix
+		if prefix == "" {
+			prefix = "kroki-"
+		}
+		svg = addTitle(svg, options.Title, options.DefaultTitle, prefix+"title")
+	}
+	return svg
+}
+
+func prefixIDs(svg string, prefix string) string {
+	ids := map[string]bool{}
+	for _, match := range svgIDPattern.FindAllStringSubmatch(svg, -1) {
+		ids[match[2]] = true
+	}
+	if len(ids) == 0 {
+		return svg
+	}
+	svg = svgIDPattern.ReplaceAllStringFunc(svg, func(match string) string {
+		return strings.Replace(match, `"`, `"`+prefix, 1)
+	})
+	svg = svgReferencePattern.ReplaceAllStringFunc(svg, func(match string) string {
+		groups := svgReferencePattern.FindStringSubmatch(match)
+		if !ids[groups[2]] {
+			return match
+		}
+		return groups[1] + prefix + groups[2]
+	})
+	// CSS selectors, for instance: #mermaid-1 .node
+	return svgStylePattern.ReplaceAllStringFunc(svg, func(match string) string {
+		groups := svgStylePattern.FindStringSubmatch(match)
+		style := svgSelectorPattern.ReplaceAllStringFunc(groups[2], func(selector string) string {
+			if !ids[selector[1:]] {
+				return selector
+			}
+			return "#" + prefix + selector[1:]
+		})
+		return groups[1] + style + groups[3]
+	})
+}
+
+func responsiveRoot(root string) string {
+	size := map[string]string{}
+	for _, match := range svgSizePattern.FindAllStringSubmatch(root, -1) {
+		size[match[1]] = match[2]
+	}
+	if !strings.Contains(root, "viewBox=") {
+		width := svgSizeValuePattern.FindStringSubmatch(size["width"])
+		height := svgSizeValuePattern.FindStringSubmatch(size["height"])
+		if width == nil || height == nil {
+			// the viewBox cannot be computed (for instance: width="100%"), the size is kept
+			return root
+		}
+		root = strings.Replace(root, "<svg", fmt.Sprintf(`<svg viewBox="0 0 %s %s"`, width[1], height[1]), 1)
+	}
+	return svgSizePattern.ReplaceAllString(root, "")
+}
+
+func addTitle(svg string, title string, defaultTitle string, titleID string) string {
+	location := svgRootPattern.FindStringIndex(svg)
+	if location == nil {
+		return svg
+	}
+	root, content := svg[location[0]:location[1]], svg[location[1]:]
+	if match := svgTitlePattern.FindStringSubmatchIndex(content); match != nil {
+		// the first child is a title, it is replaced when the title is defined
+		if title == "" {
+			title = html.UnescapeString(content[match[2]:match[3]])
+		}
+		content = content[match[1]:]
+	}
+	if title == "" {
+		title = defaultTitle
+	}
+	if title == "" {
+		return svg
+	}
+	if !strings.Contains(root, "role=") {
+		root = strings.Replace(root, "<svg", `<svg role="img"`, 1)
+	}
+	if !strings.Contains(root, "aria-labelledby=") && !strings.Contains(root, "aria-label=") {
+		root = strings.Replace(root, "<svg", `<svg aria-labelledby="`+titleID+`"`, 1)
+	}
+	element := `<title id="` + titleID + `">` + html.EscapeString(title) + `</title>`
+	return svg[:location[0]] + root + element + content
+}
+
+// DiagramTitle returns the title defined in the diagram source, for instance: title Login (PlantUML or Mermaid)
+func DiagramTitle(source string) string {
+	if match := titlePattern.FindStringSubmatch(source); match != nil {
+		return strings.Trim(match[1], `"`)
+	}
+	return ""
+}
+
+// IDPrefix returns the prefix of the IDs derived from the file name and the name of the diagram
+func IDPrefix(filePath string, name string) string {
+	base := "kroki"
+	if filePath != "" && filePath != "-" {
+		base = filepath.Base(filePath)
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if name != "" {
+		base += "-" + name
+	}
+	prefix := strings.Trim(unsafeIDPattern.ReplaceAllString(base, "-"), "-")
+	if prefix == "" {
+		prefix = "kroki"
+	}
+	// an ID must not start with a digit
+	if _, err := strconv.Atoi(prefix[:1]); err == nil {
+		prefix = "d" + prefix
+	}
+	return prefix + "-"
+}
@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"testing"
+)
+
+const graphvizSVG = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!-- Generated by graphviz -->
+<svg width="62pt" height="116pt" viewBox="0.00 0.00 62.00 116.00" xmlns="http://www.w3.org/2000/svg">
+<metadata>
+  <rdf:RDF></rdf:RDF>
+</metadata>
+<style>#node1 { fill: #fff; }</style>
+<defs><linearGradient id="gradient"/></defs>
+<g id="node1" class="node">
+<title>a</title>
+<ellipse fill="url(#gradient)" cx="27" cy="-90" rx="27" ry="18"/>
+<use xlink:href="#node1"/>
+<a href="#external"/>
+</g>
+</svg>
+`
+
+func TestTransformSVG(t *testing.T) {
+	cases := []struct {
+		svg      string
+		options  SVGOptions
+		expected string
+	}{
+		{
+			svg:     graphvizSVG,
+			options: SVGOptions{Minify: true},
+			expected: `<?xml version="1.0" encoding="UTF-8" standalone="no"?><svg width="62pt" height="116pt" viewBox="0.00 0.00 62.00 116.00" xmlns="http://www.w3.org/2000/svg">` +
+				`<style>#node1 { fill: #fff; }</style><defs><linearGradient id="gradient"/></defs><g id="node1" class="node"><title>a</title>` +
+				`<ellipse fill="url(#gradient)" cx="27" cy="-90" rx="27" ry="18"/><use xlink:href="#node1"/><a href="#external"/></g></svg>`,
+		},
+		{
+			svg:     `<svg><style>#node1 { fill: #fff; }</style><defs><linearGradient id="gradient"/></defs><g id="node1"><ellipse fill="url(#gradient)"/><use xlink:href="#node1"/><a href="#external"/></g></svg>`,
+			options: SVGOptions{PrefixIDs: true, Prefix: "login-"},
+			expected: `<svg><style>#login-node1 { fill: #fff; }</style><defs><linearGradient id="login-gradient"/></defs>` +
+				`<g id="login-node1"><ellipse fill="url(#login-gradient)"/><use xlink:href="#login-node1"/><a href="#external"/></g></svg>`,
+		},
+		{
+			// data-id is not an ID
+			svg:      `<svg><g id="node1" data-id="edge1"><use href="#node1"/><use href="#edge1"/></g></svg>`,
+			options:  SVGOptions{PrefixIDs: true, Prefix: "login-"},
+			expected: `<svg><g id="login-node1" data-id="edge1"><use href="#login-node1"/><use href="#edge1"/></g></svg>`,
+		},
+		{
+			svg:      `<svg width="62px" height="116" xmlns="http://www.w3.org/2000/svg"><g/></svg>`,
+			options:  SVGOptions{Responsive: true},
+			expected: `<svg viewBox="0 0 62 116" xmlns="http://www.w3.org/2000/svg"><g/></svg>`,
+		},
+		{
+			svg:      `<svg width="62pt" height="116pt" viewBox="0 0 62 116"><g/></svg>`,
+			options:  SVGOptions{Responsive: true},
+			expected: `<svg viewBox="0 0 62 116"><g/></svg>`,
+		},
+		{
+			svg:      `<svg width="100%"><g/></svg>`,
+			options:  SVGOptions{Responsive: true},
+			expected: `<svg width="100%"><g/></svg>`,
+		},
+		{
+			svg:      `<svg xmlns="http://www.w3.org/2000/svg"><g/></svg>`,
+			options:  SVGOptions{Title: "Login & logout", Prefix: "login-"},
+			expected: `<svg aria-labelledby="login-title" role="img" xmlns="http://www.w3.org/2000/svg"><title id="login-title">Login &amp; logout</title><g/></svg>`,
+		},
+		{
+			svg:      `<svg role="graphics-document"><title>Sequence</title><g/></svg>`,
+			options:  SVGOptions{Accessible: true, DefaultTitle: "login"},
+			expected: `<svg aria-labelledby="kroki-title" role="graphics-document"><title id="kroki-title">Sequence</title><g/></svg>`,
+		},
+		{
+			svg:      `<svg><g/></svg>`,
+			options:  SVGOptions{Accessible: true, DefaultTitle: "login"},
+			expected: `<svg aria-labelledby="kroki-title" role="img"><title id="kroki-title">login</title><g/></svg>`,
+		},
+	}
+	for _, c := range cases {
+		result := TransformSVG(c.svg, c.options)
+		if result != c.expected {
+			t.Errorf("TransformSVG(%+v) error\nexpected: %s\nactual:   %s", c.options, c.expected, result)
+		}
+	}
+}
+
+func TestIDPrefix(t *testing.T) {
+	cases := []struct {
+		filePath string
+		name     string
+		expected string
+	}{
+		{filePath: "docs/login flow.puml", expected: "login-flow-"},
+		{filePath: "docs/flows.puml", name: "2", expected: "flows-2-"},
+		{filePath: "2024-architecture.d2", expected: "d2024-architecture-"},
+		{filePath: "", expected: "kroki-"},
+	}
+	for _, c := range cases {
+		result := IDPrefix(c.filePath, c.name)
+		if result != c.expected {
+			t.Errorf("IDPrefix(%s, %s) error\nexpected: %s\nactual:   %s", c.filePath, c.name, c.expected, result)
+		}
+	}
+}
+
+func TestDiagramTitle(t *testing.T) {
+	cases := map[string]string{
+		"@startuml\ntitle Login flow\nAlice -> Bob\n@enduml": "Login flow",
+		"---\ntitle: \"Order\"\n---\nflowchart LR":           "Order",
+		"pie title Pets\n  \"Dogs\" : 386":                   "Pets",
+		"digraph { a -> b }":                                 "",
+	}
+	for source, expected := range cases {
+		result := DiagramTitle(source)
+		if result != expected {
+			t.Errorf("DiagramTitle(%q) error\nexpected: %s\nactual:   %s", source, expected, result)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// pinPrefix is the optional prefix of a public key pin (same format as curl --pinnedpubkey)
+const pinPrefix = "sha256//"
+
+// TLSConfig contains the TLS settings used to connect to Kroki
+type TLSConfig struct {
+	// CAFile is a PEM bundle of certificate authorities trusted in addition to the system ones
+	CAFile string
+	// CertFile and KeyFile are the PEM encoded client certificate and key (mTLS)
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used to verify the server certificate
+	ServerName string
+	// PinSHA256 contains the base64 encoded SHA-256 hashes of the accepted server public keys (SPKI)
+	PinSHA256 []string
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool
+}
+
+// GetTLSConfig returns the TLS settings defined in the configuration
+func GetTLSConfig() TLSConfig {
+	var pins []string
+	for _, value := range viper.GetStringSlice("tls.pin_sha256") {
+		for _, pin := range strings.Split(value, ",") {
+			if pin = strings.TrimSpace(pin); pin != "" {
+				pins = append(pins, pin)
+			}
+		}
+	}
+	return TLSConfig{
+		CAFile:             viper.GetString("tls.ca_file"),
+		CertFile:           viper.GetString("tls.cert_file"),
+		KeyFile:            viper.GetString("tls.key_file"),
+		ServerName:         viper.GetString("tls.server_name"),
+		PinSHA256:          pins,
+		InsecureSkipVerify: viper.GetBool("tls.insecure_skip_verify"),
+	}
+}
+
+// Build returns a tls.Config, or nil when the default settings should be used
+func (c TLSConfig) Build() (*tls.Config, error) {
+	if c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" && c.ServerName == "" && len(c.PinSHA256) == 0 && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+	// InsecureSkipVerify must be explicitly enabled by the user
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		content, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("fail to read the CA file %s: %w", c.CAFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificate found in the CA file %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("invalid tls: cert_file and key_file must be defined together")
+		}
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("fail to load the client certificate %s: %w", c.CertFile, err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	if len(c.PinSHA256) > 0 {
+		pins := make(map[string]bool, len(c.PinSHA256))
+		for _, pin := range c.PinSHA256 {
+			pin = strings.TrimPrefix(pin, pinPrefix)
+			if decoded, err := base64.StdEncoding.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("invalid tls pin: %s, must be a base64 encoded SHA-256 hash", pin)
+			}
+			pins[pin] = true
+		}
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) > 0 && pins[PublicKeyPin(state.PeerCertificates[0])] {
+				return nil
+			}
+			return fmt.Errorf("the server public key does not match the pinned public key")
+		}
+	}
+	return config, nil
+}
+
+// PublicKeyPin returns the base64 encoded SHA-256 hash of the certificate public key (SPKI)
+func PublicKeyPin(certificate *x509.Certificate) string {
+	hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, path string, blockType string, bytes []byte) {
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+}
+
+// generateClientCertificate writes a self-signed client certificate and its key in the directory
+func generateClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kroki-cli"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certificate, certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	clientCertificate, certFile, keyFile := generateClientCertificate(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCertificate)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<svg>Hello</svg>"))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", ts.Certificate().Raw)
+	serverPin := PublicKeyPin(ts.Certificate())
+
+	mtls := httptest.NewUnstartedServer(ts.Config.Handler)
+	mtls.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mtls.StartTLS()
+	defer mtls.Close()
+	mtlsCAFile := filepath.Join(dir, "mtls-ca.pem")
+	writePEM(t, mtlsCAFile, "CERTIFICATE", mtls.Certificate().Raw)
+
+	cases := []struct {
+		name    string
+		url     string
+		config  TLSConfig
+		success bool
+	}{
+		{
+			name:    "unknown certificate authority",
+			url:     ts.URL,
+			config:  TLSConfig{},
+			success: false,
+		},
+		{
+			name:    "custom certificate authority",
+			url:     ts.URL,
+			config:  TLSConfig{CAFile: caFile},
+			success: true,
+		},
+		{
+			name:    "server name",
+			url:     ts.URL,
+			config:  TLSConfig{CAFile: caFile, ServerName: "example.com"},
+			success: true,
+		},
+		{
+			name:    "invalid server name",
+			url:     ts.URL,
+			config:  TLSConfig{CAFile: caFile, ServerName: "kroki.example.org"},
+			success: false,
+		},
+		{
+			name:    "insecure skip verify",
+			url:     ts.URL,
+			config:  TLSConfig{InsecureSkipVerify: true},
+			success: true,
+		},
+		{
+			name:    "matching public key pin",
+			url:     ts.URL,
+			config:  TLSConfig{CAFile: caFile, PinSHA256: []string{pinPrefix + serverPin}},
+			success: true,
+		},
+		{
+			name:    "mismatching public key pin",
+			url:     ts.URL,
+			config:  TLSConfig{InsecureSkipVerify: true, PinSHA256: []string{PublicKeyPin(clientCertificate)}},
+			success: false,
+		},
+		{
+			name:    "missing client certificate",
+			url:     mtls.URL,
+			config:  TLSConfig{CAFile: mtlsCAFile},
+			success: false,
+		},
+		{
+			name:    "client certificate",
+			url:     mtls.URL,
+			config:  TLSConfig{CAFile: mtlsCAFile, CertFile: certFile, KeyFile: keyFile},
+			success: true,
+		},
+	}
+	for _, c := range cases {
+		tlsConfig, err := c.config.Build()
+		if err != nil {
+			t.Errorf("TLSConfig %s error\n%+v", c.name, err)
+			continue
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		response, err := (&http.Client{Transport: transport}).Get(c.url)
+		if err == nil {
+			_ = response.Body.Close()
+		}
+		if (err == nil) != c.success {
+			t.Errorf("TLSConfig %s error\nexpected success: %t\nactual:   %+v", c.name, c.success, err)
+		}
+		transport.CloseIdleConnections()
+	}
+}
+
+func TestTLSConfigInvalid(t *testing.T) {
+	cases := []TLSConfig{
+		{CAFile: "/path/to/missing/ca.pem"},
+		{CertFile: "client.crt"},
+		{PinSHA256: []string{"not-a-pin"}},
+	}
+	for _, c := range cases {
+		_, err := c.Build()
+		if err == nil {
+			t.Errorf("TLSConfig(%+v) error\nexpected an error", c)
+		}
+	}
+}
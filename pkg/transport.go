@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// NewTransport returns the HTTP transport used to send requests to Kroki, based on the current configuration
+func NewTransport() (http.RoundTripper, error) {
+	retryOn, err := ParseRetryOn(viper.GetStringSlice("retry_on"))
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := ParseStrategy(viper.GetString("strategy"))
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newBaseTransport()
+	if err != nil {
+		return nil, err
+	}
+	if viper.GetBool("debug") {
+		transport = newDebugTransport(transport, os.Stderr)
+	}
+	credentials, err := GetCredentials()
+	if err != nil {
+		return nil, err
+	}
+	transport, err = newAuthTransport(transport, credentials)
+	if err != nil {
+		return nil, err
+	}
+	transport, err = newBalancerTransport(transport, Endpoints(), strategy, CircuitBreaker{
+		Threshold: viper.GetInt("circuit_breaker.threshold"),
+		Cooldown:  viper.GetDuration("circuit_breaker.cooldown"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newRetryTransport(transport, RetryPolicy{
+		Retries: viper.GetInt("retries"),
+		Backoff: viper.GetDuration("retry_backoff"),
+		RetryOn: retryOn,
+	}), nil
+}
+
+// newBaseTransport returns the transport that opens the connections to Kroki
+func newBaseTransport() (http.RoundTripper, error) {
+	tlsConfig, err := GetTLSConfig().Build()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := ProxyConfig{
+		Proxy:   viper.GetString("proxy"),
+		NoProxy: viper.GetString("no_proxy"),
+	}.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.DialContext = dialContext(&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport, nil
+}
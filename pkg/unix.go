@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// unixScheme is the scheme of the endpoints using a Unix domain socket, for instance: unix:///var/run/kroki.sock
+const unixScheme = "unix"
+
+// unixHostSuffix is the suffix of the placeholder hosts standing for a Unix domain socket
+const unixHostSuffix = ".sock.localhost"
+
+var unixSockets = struct {
+	sync.RWMutex
+	paths map[string]string
+}{paths: map[string]string{}}
+
+// ResolveEndpoint returns the HTTP URL used by the Kroki client to reach the endpoint
+// an endpoint using a Unix domain socket is replaced by a placeholder host resolved when dialing
+func ResolveEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != unixScheme {
+		return endpoint
+	}
+	socketPath := u.Path
+	if socketPath == "" {
+		// unix:relative/path.sock
+		socketPath = u.Opaque
+	}
+	hash := sha256.Sum256([]byte(socketPath))
+	host := hex.EncodeToString(hash[:8]) + unixHostSuffix
+	unixSockets.Lock()
+	unixSockets.paths[host] = socketPath
+	unixSockets.Unlock()
+	return "http://" + host
+}
+
+func lookupUnixSocket(host string) (string, bool) {
+	if !strings.HasSuffix(host, unixHostSuffix) {
+		return "", false
+	}
+	unixSockets.RLock()
+	defer unixSockets.RUnlock()
+	socketPath, ok := unixSockets.paths[host]
+	return socketPath, ok
+}
+
+// dialContext dials the Unix domain socket when the address is a placeholder host, otherwise dials using TCP
+func dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil {
+			if socketPath, ok := lookupUnixSocket(host); ok {
+				return dialer.DialContext(ctx, "unix", socketPath)
+			}
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+}
@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// Template styles used to expand the variables in the diagram source
+const (
+	// ShellTemplate expands ${NAME} and ${NAME:-default}
+	ShellTemplate = "shell"
+	// GoTemplate uses the Go text/template syntax, the names are in lowercase, for instance: {{ .name }}
+	GoTemplate = "go"
+	// NoTemplate disables the expansion
+	NoTemplate = "none"
+)
+
+var shellVariablePattern = regexp.MustCompile(`\$\{([A-Za-z_][\w.-]*)(:-([^}]*))?\}`)
+
+// GetVariables returns the variables defined in the configuration (variables), in the vars_file and using --var key=value,
+// in increasing order of precedence, like the configuration keys the names are case-insensitive (returned in lowercase)
+func GetVariables() (map[string]string, error) {
+	variables := map[string]string{}
+	for name, value := range viper.GetStringMapString("variables") {
+		variables[name] = value
+	}
+	if varsFile := viper.GetString("vars_file"); varsFile != "" {
+		v := viper.New()
+		v.SetConfigFile(varsFile)
+		err := v.ReadInConfig()
+		if err != nil {
+			return nil, fmt.Errorf("fail to read the variables file %s: %w", varsFile, err)
+		}
+		// nested keys use the dot notation, for instance: database.host
+		for _, name := range v.AllKeys() {
+			variables[name] = v.GetString(name)
+		}
+	}
+	for _, value := range viper.GetStringSlice("var") {
+		i := strings.Index(value, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid variable: %s, expected key=value", value)
+		}
+		variables[strings.ToLower(value[:i])] = value[i+1:]
+	}
+	return variables, nil
+}
+
+// ExpandVariables expands the variables in the text using the template style (shell by default),
+// the text is returned as is when there are no variables and no template style is configured
+// in strict mode, undefined variables are reported as an error otherwise they are left untouched (shell) or replaced by an empty string (go)
+func ExpandVariables(text string, variables map[string]string, style string, strict bool) (string, error) {
+	if style == "" {
+		if len(variables) == 0 && !strict {
+			return text, nil
+		}
+		style = ShellTemplate
+	}
+	switch style {
+	case NoTemplate:
+		return text, nil
+	case ShellTemplate:
+		var undefined []string
+		result := shellVariablePattern.ReplaceAllStringFunc(text, func(match string) string {
+			groups := shellVariablePattern.FindStringSubmatch(match)
+			if value, ok := variables[groups[1]]; ok {
+				return value
+			}
+			if value, ok := variables[strings.ToLower(groups[1])]; ok {
+				return value
+			}
+			if groups[2] != "" {
+				return groups[3]
+			}
+			if !containsString(undefined, groups[1]) {
+				undefined = append(undefined, groups[1])
+			}
+			return match
+		})
+		if strict && len(undefined) > 0 {
+			sort.Strings(undefined)
+			return text, fmt.Errorf("undefined variables: %s", strings.Join(undefined, ", "))
+		}
+		return result, nil
+	case GoTemplate:
+		option := "missingkey=zero"
+		if strict {
+			option = "missingkey=error"
+		}
+		tmpl, err := template.New("diagram").Option(option).Parse(text)
+		if err != nil {
+			return text, fmt.Errorf("invalid template: %w", err)
+		}
+		var buffer bytes.Buffer
+		err = tmpl.Execute(&buffer, variables)
+		if err != nil {
+			return text, fmt.Errorf("fail to expand the template: %w", err)
+		}
+		return buffer.String(), nil
+	}
+	return text, fmt.Errorf("invalid template style: %s, expected one of: %s, %s, %s", style, ShellTemplate, GoTemplate, NoTemplate)
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestExpandVariables(t *testing.T) {
+	variables := map[string]string{"host": "kroki.example.com", "version": "1.2"}
+	cases := []struct {
+		text     string
+		style    string
+		strict   bool
+		expected string
+		err      string
+	}{
+		{text: "A[${HOST}] --> B[v${version}]", expected: "A[kroki.example.com] --> B[v1.2]"},
+		{text: "A[${region:-eu}] --> B[${missing}]", expected: "A[eu] --> B[${missing}]"},
+		{text: "A[${region}] --> B[${missing}] --> C[${region}]", strict: true, err: "undefined variables: missing, region"},
+		{text: "!$host = \"$x\"\nA[${host}]", style: NoTemplate, expected: "!$host = \"$x\"\nA[${host}]"},
+		{text: "A[{{ .host }}] --> B[{{ .missing }}]", style: GoTemplate, expected: "A[kroki.example.com] --> B[]"},
+		{text: "A[{{ .missing }}]", style: GoTemplate, strict: true, err: `fail to expand the template: template: diagram:1:5: executing "diagram" at <.missing>: map has no entry for key "missing"`},
+		{text: "A", style: "jinja", err: "invalid template style: jinja, expected one of: shell, go, none"},
+	}
+	for _, c := range cases {
+		result, err := ExpandVariables(c.text, variables, c.style, c.strict)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("ExpandVariables(%s) error\nexpected: %s\nactual:   %v", c.text, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ExpandVariables(%s) error\n%+v", c.text, err)
+			continue
+		}
+		if result != c.expected {
+			t.Errorf("ExpandVariables(%s) error\nexpected: %s\nactual:   %s", c.text, c.expected, result)
+		}
+	}
+	// without variables, the text is not modified
+	result, _ := ExpandVariables("A[${host}]", nil, "", false)
+	if result != "A[${host}]" {
+		t.Errorf("ExpandVariables error\nexpected: %s\nactual:   %s", "A[${host}]", result)
+	}
+}
+
+func TestGetVariables(t *testing.T) {
+	varsFile := filepath.Join(t.TempDir(), "vars.yml")
+	writeFile(t, varsFile, "host: staging.example.com\ndatabase:\n  port: 5432\n")
+	loadTestConfig(t, "variables:\n  host: kroki.example.com\n  env: production\n")
+	viper.Set("vars_file", varsFile)
+	viper.Set("var", []string{"ENV=staging"})
+	defer viper.Set("vars_file", nil)
+	defer viper.Set("var", nil)
+	result, err := GetVariables()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := map[string]string{"host": "staging.example.com", "env": "staging", "database.port": "5432"}
+	if len(result) != len(expected) {
+		t.Errorf("GetVariables error\nexpected: %v\nactual:   %v", expected, result)
+	}
+	for name, value := range expected {
+		if result[name] != value {
+			t.Errorf("GetVariables error\nexpected %s: %s\nactual:   %s", name, value, result[name])
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/yuzutech/kroki-go"
+)
+
+// Theme contains the changes applied to the diagrams of a given type to render a variant
+type Theme struct {
+	// Header is inserted at the beginning of the diagram (after @startuml for PlantUML and after the opening brace for GraphViz)
+	Header string `mapstructure:"header"`
+	// Options are sent to Kroki, they take precedence over the diagram options
+	Options map[string]string `mapstructure:"options"`
+}
+
+// Variant is a theme variant of the diagrams (for instance: dark), the theme of each diagram type is defined in themes.NAME
+type Variant struct {
+	Name   string
+	Themes map[string]Theme
+}
+
+// defaultThemes contains the built-in light and dark themes, they can be overridden in the configuration
+var defaultThemes = map[string]map[string]Theme{
+	"light": {
+		"plantuml":   {Header: "!theme plain"},
+		"c4plantuml": {Header: "!theme plain"},
+		"mermaid":    {Header: "%%{init: {'theme': 'default'}}%%"},
+		"d2":         {Options: map[string]string{"theme": "0"}},
+		"graphviz":   {Header: `bgcolor="white"`},
+	},
+	"dark": {
+		"plantuml":   {Header: "!theme cyborg"},
+		"c4plantuml": {Header: "!theme cyborg"},
+		"mermaid":    {Header: "%%{init: {'theme': 'dark'}}%%"},
+		"d2":         {Options: map[string]string{"theme": "200"}},
+		"graphviz": {Header: `bgcolor="#0d1117" node [color="#c9d1d9", fontcolor="#c9d1d9"] ` +
+			`edge [color="#c9d1d9", fontcolor="#c9d1d9"] graph [fontcolor="#c9d1d9"]`},
+	},
+}
+
+// GetVariants returns the variants selected using --variants, each variant must be a built-in theme (light or dark) or defined in themes
+func GetVariants() ([]Variant, error) {
+	var variants []Variant
+	for _, value := range viper.GetStringSlice("variants") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			themes := map[string]Theme{}
+			for diagramType, theme := range defaultThemes[name] {
+				themes[diagramType] = theme
+			}
+			var configured map[string]Theme
+			err := viper.UnmarshalKey("themes."+name, &configured)
+			if err != nil {
+				return nil, fmt.Errorf("invalid themes.%s: %w", name, err)
+			}
+			for diagramType, theme := range configured {
+				themes[strings.ToLower(diagramType)] = theme
+			}
+			if len(themes) == 0 {
+				return nil, fmt.Errorf("unknown variant: %s, expected one of: %s (or a variant defined in themes)", name, strings.Join(VariantNames(), ", "))
+			}
+			variants = append(variants, Variant{Name: name, Themes: themes})
+		}
+	}
+	return variants, nil
+}
+
+var (
+	graphvizOpeningPattern = regexp.MustCompile(`(?s)^(.*?graph\b[^{]*\{)`)
+	frontMatterPattern     = regexp.MustCompile(`(?s)^---\r?\n.*?\n---\r?\n`)
+)
+
+// Apply returns the client and the diagram source used to render the variant
+func (v Variant) Apply(client kroki.Client, text string, diagramType kroki.DiagramType) (kroki.Client, string, error) {
+	theme, ok := v.Themes[string(diagramType)]
+	if !ok {
+		return client, text, nil
+	}
+	client, err := WithOptions(client, theme.Options)
+	if err != nil {
+		return client, text, err
+	}
+	if theme.Header == "" {
+		return client, text, nil
+	}
+	switch {
+	case isPlantUML(diagramType):
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			if startPattern.MatchString(strings.TrimRight(line, "\r")) {
+				lines[i] = line + "\n" + theme.Header
+				return client, strings.Join(lines, "\n"), nil
+			}
+		}
+	case diagramType == kroki.Mermaid:
+		// the directive is inserted after the front matter
+		if location := frontMatterPattern.FindStringIndex(text); location != nil {
+			return client, text[:location[1]] + theme.Header + "\n" + text[location[1]:], nil
+		}
+	case diagramType == kroki.GraphViz:
+		if location := graphvizOpeningPattern.FindStringIndex(text); location != nil {
+			return client, text[:location[1]] + "\n" + theme.Header + "\n" + text[location[1]:], nil
+		}
+	}
+	return client, theme.Header + "\n" + text, nil
+}
+
+// VariantOutputFilePath returns the output file of the variant, for instance: login.dark.svg
+func VariantOutputFilePath(outFile string, variant string) string {
+	extension := filepath.Ext(outFile)
+	return strings.TrimSuffix(outFile, extension) + "." + variant + extension
+}
+
+// colorSchemes contains the media queries of the variants used in the picture element
+var colorSchemes = map[string]string{
+	"light": "(prefers-color-scheme: light)",
+	"dark":  "(prefers-color-scheme: dark)",
+}
+
+// PictureSnippet returns an HTML picture element which selects the image matching the color scheme of the reader,
+// outputs contains the output file of each variant (in order), the first variant is the fallback image
+func PictureSnippet(variants []Variant, outputs []string, alt string) string {
+	var builder strings.Builder
+	builder.WriteString("<picture>\n")
+	for i, variant := range variants {
+		if media, ok := colorSchemes[variant.Name]; ok && i > 0 {
+			fmt.Fprintf(&builder, "  <source srcset=\"%s\" media=\"%s\">\n", html.EscapeString(filepath.Base(outputs[i])), media)
+		}
+	}
+	fmt.Fprintf(&builder, "  <img src=\"%s\" alt=\"%s\">\n", html.EscapeString(filepath.Base(outputs[0])), html.EscapeString(alt))
+	builder.WriteString("</picture>\n")
+	return builder.String()
+}
+
+// WritePictureSnippet writes the picture element next to the images, for instance: login.picture.html
+func WritePictureSnippet(outFile string, variants []Variant, outputs []string, alt string) (string, error) {
+	snippetFile := strings.TrimSuffix(outFile, filepath.Ext(outFile)) + ".picture.html"
+	err := os.WriteFile(snippetFile, []byte(PictureSnippet(variants, outputs, alt)), 0644)
+	if err != nil {
+		return "", fmt.Errorf("fail to write file %s: %w", snippetFile, err)
+	}
+	return snippetFile, nil
+}
+
+// VariantNames returns the names of the built-in and configured variants
+func VariantNames() []string {
+	names := map[string]bool{}
+	for name := range defaultThemes {
+		names[name] = true
+	}
+	for name := range viper.GetStringMap("themes") {
+		names[name] = true
+	}
+	var result []string
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yuzutech/kroki-go"
+)
+
+func TestGetVariants(t *testing.T) {
+	loadTestConfig(t, `
+variants: light, dark, print
+themes:
+  dark:
+    plantuml:
+      header: "!theme amiga"
+  print:
+    vegalite:
+      options:
+        theme: excel
+`)
+	variants, err := GetVariants()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(variants) != 3 || variants[0].Name != "light" || variants[1].Name != "dark" || variants[2].Name != "print" {
+		t.Fatalf("GetVariants error\nexpected: light, dark, print\nactual:   %+v", variants)
+	}
+	// the configured theme replaces the built-in theme of the diagram type, the other built-in themes are kept
+	if header := variants[1].Themes["plantuml"].Header; header != "!theme amiga" {
+		t.Errorf("GetVariants error\nexpected: %s\nactual:   %s", "!theme amiga", header)
+	}
+	if header := variants[1].Themes["mermaid"].Header; header != "%%{init: {'theme': 'dark'}}%%" {
+		t.Errorf("GetVariants error\nexpected: %s\nactual:   %s", "%%{init: {'theme': 'dark'}}%%", header)
+	}
+	if theme := variants[2].Themes["vegalite"].Options["theme"]; theme != "excel" {
+		t.Errorf("GetVariants error\nexpected: %s\nactual:   %s", "excel", theme)
+	}
+}
+
+func TestGetVariantsUnknown(t *testing.T) {
+	loadTestConfig(t, `variants: [sepia]`)
+	_, err := GetVariants()
+	expected := "unknown variant: sepia, expected one of: dark, light (or a variant defined in themes)"
+	if err == nil || err.Error() != expected {
+		t.Errorf("GetVariants error\nexpected: %s\nactual:   %v", expected, err)
+	}
+}
+
+func TestVariantApply(t *testing.T) {
+	dark := Variant{Name: "dark", Themes: defaultThemes["dark"]}
+	cases := []struct {
+		diagramType kroki.DiagramType
+		text        string
+		expected    string
+	}{
+		{
+			diagramType: kroki.PlantUML,
+			text:        "' comment\n@startuml\nAlice -> Bob\n@enduml",
+			expected:    "' comment\n@startuml\n!theme cyborg\nAlice -> Bob\n@enduml",
+		},
+		{
+			diagramType: kroki.PlantUML,
+			text:        "Alice -> Bob",
+			expected:    "!theme cyborg\nAlice -> Bob",
+		},
+		{
+			diagramType: kroki.Mermaid,
+			text:        "---\ntitle: Order\n---\nflowchart LR\n  a --> b",
+			expected:    "---\ntitle: Order\n---\n%%{init: {'theme': 'dark'}}%%\nflowchart LR\n  a --> b",
+		},
+		{
+			diagramType: kroki.Mermaid,
+			text:        "flowchart LR\n  a --> b",
+			expected:    "%%{init: {'theme': 'dark'}}%%\nflowchart LR\n  a --> b",
+		},
+		{
+			diagramType: kroki.GraphViz,
+			text:        "strict digraph G { a -> b }",
+			expected:    "strict digraph G {\n" + defaultThemes["dark"]["graphviz"].Header + "\n a -> b }",
+		},
+		{
+			diagramType: kroki.Ditaa,
+			text:        "+---+",
+			expected:    "+---+",
+		},
+	}
+	client := kroki.New(kroki.Configuration{URL: "https://kroki.example.com", Timeout: time.Second})
+	for _, c := range cases {
+		_, result, err := dark.Apply(client, c.text, c.diagramType)
+		if err != nil {
+			t.Errorf("unexpected error: %+v", err)
+			continue
+		}
+		if result != c.expected {
+			t.Errorf("Variant.Apply(%s) error\nexpected: %s\nactual:   %s", c.diagramType, c.expected, result)
+		}
+	}
+	result, _, err := dark.Apply(client, "a -> b", kroki.D2)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := "https://kroki.example.com?theme=200"
+	if result.Config.URL != expected {
+		t.Errorf("Variant.Apply(d2) error\nexpected: %s\nactual:   %s", expected, result.Config.URL)
+	}
+}
+
+func TestVariantOutputFilePath(t *testing.T) {
+	cases := map[string]string{
+		"docs/login.svg":   "docs/login.dark.svg",
+		"docs/login-2.png": "docs/login-2.dark.png",
+		"login":            "login.dark",
+	}
+	for outFile, expected := range cases {
+		result := VariantOutputFilePath(outFile, "dark")
+		if result != expected {
+			t.Errorf("VariantOutputFilePath(%s) error\nexpected: %s\nactual:   %s", outFile, expected, result)
+		}
+	}
+}
+
+func TestPictureSnippet(t *testing.T) {
+	variants := []Variant{{Name: "light"}, {Name: "dark"}}
+	result := PictureSnippet(variants, []string{"docs/login.light.svg", "docs/login.dark.svg"}, "Login & logout")
+	expected := `<picture>
+  <source srcset="login.dark.svg" media="(prefers-color-scheme: dark)">
+  <img src="login.light.svg" alt="Login &amp; logout">
+</picture>
+`
+	if result != expected {
+		t.Errorf("PictureSnippet error\nexpected: %s\nactual:   %s", expected, result)
+	}
+}